@@ -0,0 +1,198 @@
+package sqlw
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+// The following errors are returned or passed to the functions registered by Tx.AfterRollback for the transactions begun by DB.Begin.
+var (
+	// ErrManagedTx is returned when committing or rolling back the transaction of DB.Transaction by hand.
+	ErrManagedTx = errors.New("transaction is managed by DB.Transaction")
+	// ErrTxRolledBack is passed to the rollback functions when the transaction is rolled back by Tx.Rollback.
+	ErrTxRolledBack = errors.New("transaction is rolled back")
+	// ErrTxTooOld is passed to the rollback functions when the transaction is rolled back by WatchTxAge.
+	ErrTxTooOld = errors.New("transaction is rolled back because it exceeded the max age")
+	// ErrTxStmtUnsupported is returned by Tx.Stmt for the transaction with the consistent snapshot.
+	ErrTxStmtUnsupported = errors.New("statement cannot be used in the transaction started by the statement")
+)
+
+// Statement is a statement validated before it is executed, SQLQuery or SQLMutation.
+type Statement interface {
+	Validate() error
+	String() string
+}
+
+// Begin begins the transaction. The caller must call Commit or Rollback of the transaction.
+// It is for the transactions that do not fit in TxHandlerFunc, such as streaming imports,
+// and the transaction is begun on the read replica if opts.ReadOnly is true as DB.TransactionTx.
+//
+// The transaction is rolled back if the context is canceled,
+// and by WatchTxAge if it is left open longer than the age set by SetMaxTxAge.
+// The default transaction timeout by SetDefaultTimeouts is not applied, SetMaxTxAge limits the transaction instead.
+func (db *DB) Begin(ctx context.Context, opts *sql.TxOptions) (*Tx, error) {
+	n := db.getMaster()
+	if opts != nil && opts.ReadOnly {
+		n = db.getReplica()
+	}
+	tx, err := db.begin(ctx, n, opts)
+	if err != nil {
+		return nil, err
+	}
+	tx.node.acquire()
+	tx.manual = true
+	tx.started = time.Now()
+	tx.caller = callerFrom(ctx)
+
+	db.txMu.Lock()
+	defer db.txMu.Unlock()
+	if db.txs == nil {
+		db.txs = map[*Tx]struct{}{}
+	}
+	db.txs[tx] = struct{}{}
+	return tx, nil
+}
+
+// Commit commits the transaction begun by DB.Begin, and calls the functions registered by AfterCommit,
+// or the functions registered by AfterRollback if the commit fails.
+// It returns sql.ErrTxDone if the transaction has already ended.
+func (tx *Tx) Commit(ctx context.Context) error {
+	if err := tx.finish(); err != nil {
+		return err
+	}
+	err := tx.commit(ctx)
+	tx.node.release()
+	if err != nil {
+		tx.db.detectMasterOnTx(ctx, err, tx)
+	}
+	tx.runCallbacks(ctx, err)
+	return err
+}
+
+// Rollback rolls back the transaction begun by DB.Begin, and calls the functions registered by AfterRollback with ErrTxRolledBack.
+// It returns sql.ErrTxDone if the transaction has already ended.
+func (tx *Tx) Rollback(ctx context.Context) error {
+	return tx.abort(ctx, ErrTxRolledBack)
+}
+
+func (tx *Tx) abort(ctx context.Context, cause error) error {
+	if err := tx.finish(); err != nil {
+		return err
+	}
+	err := tx.rollback(ctx)
+	tx.node.release()
+	tx.runCallbacks(ctx, cause)
+	return err
+}
+
+// finish marks the end of the transaction begun by DB.Begin.
+func (tx *Tx) finish() error {
+	if !tx.manual {
+		return ErrManagedTx
+	}
+	tx.endMu.Lock()
+	defer tx.endMu.Unlock()
+	if tx.ended {
+		return sql.ErrTxDone
+	}
+	tx.ended = true
+
+	tx.db.txMu.Lock()
+	defer tx.db.txMu.Unlock()
+	delete(tx.db.txs, tx)
+	return nil
+}
+
+// Prepare creates a prepared statement for use within the transaction.
+// The statement is closed when the transaction ends.
+// It returns ErrReadOnlyTx for SQLMutation in the read only transaction.
+func (tx *Tx) Prepare(ctx context.Context, query Statement) (*sql.Stmt, error) {
+	if err := query.Validate(); err != nil {
+		return nil, err
+	}
+	if _, ok := query.(SQLMutation); ok && tx.readOnly {
+		return nil, ErrReadOnlyTx
+	}
+	var stmt *sql.Stmt
+	info := &QueryInfo{Op: OpPrepare, Query: query.String(), InTx: true}
+	err := tx.db.run(ctx, info, tx.node, func(ctx context.Context) error {
+		var err error
+		stmt, err = tx.parent.PrepareContext(ctx, query.String())
+		return err
+	})
+	return stmt, err
+}
+
+// Stmt returns a transaction-specific prepared statement from an existing statement prepared on the same node,
+// such as the statement of DB.PrepareMutation for the transaction on the master.
+// It returns ErrTxStmtUnsupported for the read only transaction with the consistent snapshot on MySQL.
+func (tx *Tx) Stmt(ctx context.Context, stmt *sql.Stmt) (*sql.Stmt, error) {
+	origin, ok := tx.parent.(*sql.Tx)
+	if !ok {
+		return nil, ErrTxStmtUnsupported
+	}
+	return origin.StmtContext(ctx, stmt), nil
+}
+
+// SetMaxTxAge sets the max age of the transactions begun by DB.Begin.
+// The transactions older than the age are logged and rolled back by WatchTxAge. 0 disables it.
+func (db *DB) SetMaxTxAge(d time.Duration) {
+	atomic.StoreInt64(&db.maxTxAge, int64(d))
+}
+
+// WatchTxAge rolls back the transactions older than the max age at every interval until the context is done.
+// It is a safety net for the transactions that the caller forgot to end,
+// which hold the connection and the locks on the database.
+//
+// It blocks until the context is done, so it is usually called in a new goroutine.
+func (db *DB) WatchTxAge(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			db.reapTxs(ctx)
+		}
+	}
+}
+
+// reapTxs rolls back the transactions older than the max age.
+func (db *DB) reapTxs(ctx context.Context) {
+	maxAge := time.Duration(atomic.LoadInt64(&db.maxTxAge))
+	if maxAge <= 0 {
+		return
+	}
+
+	now := time.Now()
+	list := []*Tx{}
+	db.txMu.Lock()
+	for tx := range db.txs {
+		if now.Sub(tx.started) > maxAge {
+			list = append(list, tx)
+		}
+	}
+	db.txMu.Unlock()
+
+	for _, tx := range list {
+		if err := tx.abort(ctx, ErrTxTooOld); err == sql.ErrTxDone {
+			// It has ended in the meantime.
+			continue
+		}
+		age := now.Sub(tx.started)
+		fields := map[string]interface{}{
+			"node":    tx.node.name,
+			"age":     age,
+			"started": tx.started,
+		}
+		if tx.caller != "" {
+			fields["caller"] = tx.caller
+		}
+		db.getLogger().Log(ctx, LevelWarn, "sqlw: transaction rolled back by max age", fields)
+		db.emit(Event{Type: EventTxTooOld, Node: tx.node.name, Err: ErrTxTooOld})
+	}
+}
@@ -0,0 +1,261 @@
+package sqlw_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/glassonion1/sqlw"
+	"github.com/glassonion1/sqlw/sqlwtest"
+)
+
+func TestDBBegin(t *testing.T) {
+	tests := []struct {
+		name  string
+		end   func(ctx context.Context, tx *sqlw.Tx) error
+		stmts []string
+		calls []string
+	}{
+		{
+			name:  "commit",
+			end:   func(ctx context.Context, tx *sqlw.Tx) error { return tx.Commit(ctx) },
+			stmts: []string{"BEGIN", "UPDATE users SET name='foo'", "COMMIT"},
+			calls: []string{"commit"},
+		},
+		{
+			name:  "rollback",
+			end:   func(ctx context.Context, tx *sqlw.Tx) error { return tx.Rollback(ctx) },
+			stmts: []string{"BEGIN", "UPDATE users SET name='foo'", "ROLLBACK"},
+			calls: []string{"rollback:" + sqlw.ErrTxRolledBack.Error()},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			c := sqlwtest.NewCluster(t)
+			db := sqlw.NewDB(c.Open(t, "master"), c.Open(t, "replica0"))
+			ctx := context.Background()
+
+			tx, err := db.Begin(ctx, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			calls := []string{}
+			tx.AfterCommit(func(ctx context.Context) {
+				calls = append(calls, "commit")
+			})
+			tx.AfterRollback(func(ctx context.Context, err error) {
+				calls = append(calls, "rollback:"+err.Error())
+			})
+			if _, err := tx.Exec(ctx, "UPDATE users SET name='foo'"); err != nil {
+				t.Fatal(err)
+			}
+			if err := tt.end(ctx, tx); err != nil {
+				t.Fatal(err)
+			}
+
+			if diff := cmp.Diff(c.Stmts("master"), tt.stmts); diff != "" {
+				t.Errorf("failed to execute transaction: %v", diff)
+			}
+			if diff := cmp.Diff(calls, tt.calls); diff != "" {
+				t.Errorf("failed to call callbacks: %v", diff)
+			}
+			if got := db.Nodes()[0].Stats.InUse; got != 0 {
+				t.Errorf("connection should be returned to the pool: %d", got)
+			}
+			// The transaction has ended.
+			if err := tx.Commit(ctx); err != sql.ErrTxDone {
+				t.Errorf("should be error of %v but got: %v", sql.ErrTxDone, err)
+			}
+			if err := tx.Rollback(ctx); err != sql.ErrTxDone {
+				t.Errorf("should be error of %v but got: %v", sql.ErrTxDone, err)
+			}
+		})
+	}
+}
+
+func TestDBBeginReadOnly(t *testing.T) {
+	c := sqlwtest.NewCluster(t)
+	db := sqlw.NewDB(c.Open(t, "master"), c.Open(t, "replica0"))
+	ctx := context.Background()
+
+	tx, err := db.Begin(ctx, &sql.TxOptions{ReadOnly: true, Isolation: sql.LevelSnapshot})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tx.Exec(ctx, "DELETE FROM users"); err != sqlw.ErrReadOnlyTx {
+		t.Errorf("should be error of %v but got: %v", sqlw.ErrReadOnlyTx, err)
+	}
+	stmt, err := db.PrepareQueryForMaster(ctx, "SELECT node")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stmt.Close()
+	if _, err := tx.Stmt(ctx, stmt); err != sqlw.ErrTxStmtUnsupported {
+		t.Errorf("should be error of %v but got: %v", sqlw.ErrTxStmtUnsupported, err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"START TRANSACTION READ ONLY, WITH CONSISTENT SNAPSHOT", "COMMIT"}
+	if diff := cmp.Diff(c.Stmts("replica0"), want); diff != "" {
+		t.Errorf("failed to execute transaction: %v", diff)
+	}
+}
+
+func TestTxPrepare(t *testing.T) {
+	c := sqlwtest.NewCluster(t)
+	db := sqlw.NewDB(c.Open(t, "master"))
+	ctx := context.Background()
+
+	stmt, err := db.PrepareMutation(ctx, "INSERT INTO logs(id) VALUES(?)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stmt.Close()
+
+	tx, err := db.Begin(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tx.Prepare(ctx, sqlw.SQLQuery("foo")); err != sqlw.ErrNotSQLQuery {
+		t.Errorf("should be error of %v but got: %v", sqlw.ErrNotSQLQuery, err)
+	}
+	prepared, err := tx.Prepare(ctx, sqlw.SQLMutation("UPDATE users SET name=?"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	txStmt, err := tx.Stmt(ctx, stmt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := prepared.ExecContext(ctx, "foo"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := txStmt.ExecContext(ctx, i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"PREPARE INSERT INTO logs(id) VALUES(?)",
+		"BEGIN",
+		"PREPARE UPDATE users SET name=?",
+		"UPDATE users SET name=?",
+		"INSERT INTO logs(id) VALUES(?)",
+		"UPDATE users SET name=?",
+		"INSERT INTO logs(id) VALUES(?)",
+		"COMMIT",
+	}
+	if diff := cmp.Diff(c.Stmts("master"), want); diff != "" {
+		t.Errorf("failed to execute statements: %v", diff)
+	}
+}
+
+func TestTxPrepareReadOnly(t *testing.T) {
+	c := sqlwtest.NewCluster(t)
+	db := sqlw.NewDB(c.Open(t, "master"), c.Open(t, "replica0"))
+	ctx := context.Background()
+
+	tx, err := db.Begin(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Prepare(ctx, sqlw.SQLMutation("UPDATE users SET name=?")); err != sqlw.ErrReadOnlyTx {
+		t.Errorf("should be error of %v but got: %v", sqlw.ErrReadOnlyTx, err)
+	}
+	stmt, err := tx.Prepare(ctx, sqlw.SQLQuery("SELECT node"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var name string
+	if err := stmt.QueryRowContext(ctx).Scan(&name); err != nil || name != "replica0" {
+		t.Errorf("should query on the replica: %v %s", err, name)
+	}
+}
+
+func TestTxCommitManaged(t *testing.T) {
+	c := sqlwtest.NewCluster(t)
+	db := sqlw.NewDB(c.Open(t, "master"))
+
+	err := db.Transaction(context.Background(), func(ctx context.Context, tx *sqlw.Tx) error {
+		if err := tx.Commit(ctx); err != sqlw.ErrManagedTx {
+			t.Errorf("should be error of %v but got: %v", sqlw.ErrManagedTx, err)
+		}
+		if err := tx.Rollback(ctx); err != sqlw.ErrManagedTx {
+			t.Errorf("should be error of %v but got: %v", sqlw.ErrManagedTx, err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDBWatchTxAge(t *testing.T) {
+	c := sqlwtest.NewCluster(t)
+	db := sqlw.NewDB(c.Open(t, "master"))
+	l := &memLogger{}
+	db.SetLogger(l)
+	db.SetMaxTxAge(50 * time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events := make(chan sqlw.Event, 1)
+	db.OnEvent(func(e sqlw.Event) {
+		events <- e
+	})
+
+	old, err := db.Begin(sqlw.WithCaller(ctx, "import"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rollbackErr error
+	old.AfterRollback(func(ctx context.Context, err error) {
+		rollbackErr = err
+	})
+	go db.WatchTxAge(ctx, 10*time.Millisecond)
+
+	var e sqlw.Event
+	select {
+	case e = <-events:
+	case <-time.After(time.Second):
+		t.Fatal("should roll back the old transaction")
+	}
+	if e.Type != sqlw.EventTxTooOld || e.Node != "master" || !errors.Is(e.Err, sqlw.ErrTxTooOld) {
+		t.Errorf("unexpected event: %+v", e)
+	}
+	if !errors.Is(rollbackErr, sqlw.ErrTxTooOld) {
+		t.Errorf("should be error of %v but got: %v", sqlw.ErrTxTooOld, rollbackErr)
+	}
+	if err := old.Commit(ctx); err != sql.ErrTxDone {
+		t.Errorf("should be error of %v but got: %v", sql.ErrTxDone, err)
+	}
+	if diff := cmp.Diff(c.Stmts("master"), []string{"BEGIN", "ROLLBACK"}); diff != "" {
+		t.Errorf("failed to rollback: %v", diff)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.records) != 1 {
+		t.Fatalf("should log the transaction: %+v", l.records)
+	}
+	r := l.records[0]
+	if r.level != sqlw.LevelWarn || r.fields["caller"] != "import" || r.fields["node"] != "master" {
+		t.Errorf("unexpected record: %+v", r)
+	}
+}
@@ -0,0 +1,245 @@
+package sqlw
+
+import (
+	"sync"
+	"time"
+)
+
+// BreakerState is the state of the circuit breaker of a node.
+type BreakerState string
+
+// The following states are the states of the circuit breaker.
+const (
+	// BreakerClosed passes the calls to the node.
+	BreakerClosed BreakerState = "closed"
+	// BreakerOpen removes the replica from the selection.
+	BreakerOpen BreakerState = "open"
+	// BreakerHalfOpen passes a limited number of the calls to the replica to probe it.
+	BreakerHalfOpen BreakerState = "half_open"
+)
+
+// BreakerPolicy is the policy of the circuit breakers of the nodes.
+type BreakerPolicy struct {
+	// Window is the period the error rate is measured over.
+	Window time.Duration
+	// MinRequests is the number of the calls in the window needed to open the breaker.
+	MinRequests int
+	// ErrorRate is the rate of the failed calls in the window to open the breaker, such as 0.5.
+	ErrorRate float64
+	// SlowCall is the duration above which the call is counted as failed, 0 disables it.
+	SlowCall time.Duration
+	// OpenTimeout is the duration the breaker stays open before probing the replica.
+	OpenTimeout time.Duration
+	// HalfOpenRequests is the number of the successful probes to close the breaker,
+	// and also the max number of the probes in flight.
+	HalfOpenRequests int
+}
+
+// DefaultBreakerPolicy is a policy for the common cases.
+var DefaultBreakerPolicy = BreakerPolicy{
+	Window:           10 * time.Second,
+	MinRequests:      20,
+	ErrorRate:        0.5,
+	OpenTimeout:      5 * time.Second,
+	HalfOpenRequests: 3,
+}
+
+// breaker is the circuit breaker of a node.
+type breaker struct {
+	mu          sync.Mutex
+	state       BreakerState
+	windowStart time.Time
+	requests    int
+	failures    int
+	openedAt    time.Time
+	probes      int
+	successes   int
+}
+
+// SetBreakerPolicy sets the policy of the circuit breakers of the nodes, nil disables the circuit breakers.
+//
+// The calls failed with the connection errors or the timeouts, and the calls slower than SlowCall are counted as failed.
+// The breaker of the replica opens when the error rate exceeds the limit or the health check fails,
+// and the replica is removed from the selection. After OpenTimeout or the successful health check,
+// the breaker becomes half-open and the replica receives a limited number of the calls to probe it.
+// The master is never removed from the selection, but the state of its breaker is reported.
+func (db *DB) SetBreakerPolicy(p *BreakerPolicy) {
+	db.breakerPolicy.Store(p)
+}
+
+func (db *DB) getBreakerPolicy() *BreakerPolicy {
+	p, _ := db.breakerPolicy.Load().(*BreakerPolicy)
+	return p
+}
+
+func (b *breaker) getState() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == "" {
+		return BreakerClosed
+	}
+	return b.state
+}
+
+// ready reports whether the node can receive a call.
+func (b *breaker) ready(p *BreakerPolicy, now time.Time) bool {
+	if p == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case BreakerOpen:
+		if now.Sub(b.openedAt) < p.OpenTimeout {
+			return false
+		}
+		b.halfOpen()
+		return true
+	case BreakerHalfOpen:
+		return b.probes < p.HalfOpenRequests
+	}
+	return true
+}
+
+// admit marks the start of a probe if the breaker is half-open.
+func (b *breaker) admit() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == BreakerHalfOpen {
+		b.probes++
+	}
+}
+
+// record records the result of a call, and returns the new state if it is changed.
+func (b *breaker) record(p *BreakerPolicy, failed bool, now time.Time) (BreakerState, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == BreakerOpen && now.Sub(b.openedAt) >= p.OpenTimeout {
+		// The master is not probed by the selection, so it is probed by the calls after the timeout.
+		b.halfOpen()
+	}
+	switch b.state {
+	case BreakerOpen:
+		return "", false
+	case BreakerHalfOpen:
+		if b.probes > 0 {
+			b.probes--
+		}
+		if failed {
+			b.open(now)
+			return BreakerOpen, true
+		}
+		b.successes++
+		if b.successes >= p.HalfOpenRequests {
+			b.close(now)
+			return BreakerClosed, true
+		}
+		return "", false
+	}
+
+	if now.Sub(b.windowStart) >= p.Window {
+		b.windowStart = now
+		b.requests = 0
+		b.failures = 0
+	}
+	b.requests++
+	if failed {
+		b.failures++
+	}
+	if b.requests >= p.MinRequests && float64(b.failures) >= p.ErrorRate*float64(b.requests) && b.failures > 0 {
+		b.open(now)
+		return BreakerOpen, true
+	}
+	return "", false
+}
+
+// trip opens the breaker, and reports whether it is changed.
+func (b *breaker) trip(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == BreakerOpen {
+		return false
+	}
+	b.open(now)
+	return true
+}
+
+// probe makes the open breaker half-open, and reports whether it is changed.
+func (b *breaker) probe() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state != BreakerOpen {
+		return false
+	}
+	b.halfOpen()
+	return true
+}
+
+func (b *breaker) open(now time.Time) {
+	b.state = BreakerOpen
+	b.openedAt = now
+	b.probes = 0
+	b.successes = 0
+}
+
+func (b *breaker) halfOpen() {
+	b.state = BreakerHalfOpen
+	b.probes = 0
+	b.successes = 0
+}
+
+func (b *breaker) close(now time.Time) {
+	b.state = BreakerClosed
+	b.windowStart = now
+	b.requests = 0
+	b.failures = 0
+}
+
+// isBreakerFailure reports whether the call is counted as failed by the circuit breaker.
+func isBreakerFailure(p *BreakerPolicy, err error, d time.Duration) bool {
+	switch ClassifyError(err) {
+	case ErrorClassConnection, ErrorClassTimeout:
+		return true
+	}
+	return p.SlowCall > 0 && d >= p.SlowCall
+}
+
+// isBreakerOp reports whether the call of the operation is counted by the circuit breaker.
+func isBreakerOp(op Op) bool {
+	switch op {
+	case OpQuery, OpExec, OpPrepare, OpBegin:
+		return true
+	}
+	return false
+}
+
+// admitBreaker marks the start of the call on the node, which is a probe if the circuit breaker is half-open.
+// It is called when the call is issued, so that the probes of the nodes selected but not used are not counted.
+func (db *DB) admitBreaker(n *node, info *QueryInfo) {
+	if db.getBreakerPolicy() == nil || !isBreakerOp(info.Op) {
+		return
+	}
+	n.breaker.admit()
+}
+
+// recordBreaker records the result of the call on the node to its circuit breaker.
+func (db *DB) recordBreaker(n *node, info *QueryInfo) {
+	p := db.getBreakerPolicy()
+	if p == nil || !isBreakerOp(info.Op) {
+		return
+	}
+	if state, ok := n.breaker.record(p, isBreakerFailure(p, info.Err, info.Duration), time.Now()); ok {
+		db.emitBreaker(n.name, state, info.Err)
+	}
+}
+
+func (db *DB) emitBreaker(name string, state BreakerState, err error) {
+	switch state {
+	case BreakerOpen:
+		db.emit(Event{Type: EventBreakerOpened, Node: name, Err: err})
+	case BreakerHalfOpen:
+		db.emit(Event{Type: EventBreakerHalfOpen, Node: name})
+	case BreakerClosed:
+		db.emit(Event{Type: EventBreakerClosed, Node: name})
+	}
+}
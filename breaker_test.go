@@ -0,0 +1,140 @@
+package sqlw_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/glassonion1/sqlw"
+	"github.com/glassonion1/sqlw/sqlwtest"
+)
+
+// breakerOf returns the state of the circuit breaker of the node.
+func breakerOf(db *sqlw.DB, name string) sqlw.BreakerState {
+	for _, n := range db.Nodes() {
+		if n.Name == name {
+			return n.Breaker
+		}
+	}
+	return ""
+}
+
+func TestDBSetBreakerPolicy(t *testing.T) {
+	c := sqlwtest.NewCluster(t)
+	db := sqlw.NewDB(c.Open(t, "master"), c.Open(t, "replica0"), c.Open(t, "replica1"))
+	db.SetBreakerPolicy(&sqlw.BreakerPolicy{
+		Window:           time.Minute,
+		MinRequests:      4,
+		ErrorRate:        0.5,
+		OpenTimeout:      50 * time.Millisecond,
+		HalfOpenRequests: 2,
+	})
+	r := &eventRecorder{}
+	db.OnEvent(r.handle)
+	c.Update("replica0", func(n *sqlwtest.Node) {
+		n.Down = true
+	})
+
+	for i := 0; i < 50; i++ {
+		var name string
+		_ = db.QueryRow(context.Background(), "SELECT node").Scan(&name)
+	}
+	if got := breakerOf(db, "replica0"); got != sqlw.BreakerOpen {
+		t.Fatalf("breaker should be open but got: %s", got)
+	}
+	if diff := cmp.Diff(queriedNodes(t, db, 20), map[string]bool{"replica1": true}); diff != "" {
+		t.Errorf("should remove the replica from the selection: %v", diff)
+	}
+
+	c.Update("replica0", func(n *sqlwtest.Node) {
+		n.Down = false
+	})
+	time.Sleep(60 * time.Millisecond)
+	want := map[string]bool{"replica0": true, "replica1": true}
+	if diff := cmp.Diff(queriedNodes(t, db, 100), want); diff != "" {
+		t.Errorf("should return the replica to the selection: %v", diff)
+	}
+	if got := breakerOf(db, "replica0"); got != sqlw.BreakerClosed {
+		t.Errorf("breaker should be closed but got: %s", got)
+	}
+	wantEvents := []sqlw.EventType{sqlw.EventBreakerOpened, sqlw.EventBreakerClosed}
+	if diff := cmp.Diff(r.types(), wantEvents); diff != "" {
+		t.Errorf("failed to notify events: %v", diff)
+	}
+}
+
+func TestDBSetBreakerPolicySlowCall(t *testing.T) {
+	c := sqlwtest.NewCluster(t)
+	db := sqlw.NewDB(c.Open(t, "master"))
+	db.SetBreakerPolicy(&sqlw.BreakerPolicy{
+		Window:           time.Minute,
+		MinRequests:      2,
+		ErrorRate:        1,
+		SlowCall:         10 * time.Millisecond,
+		OpenTimeout:      time.Minute,
+		HalfOpenRequests: 1,
+	})
+	c.Update("master", func(n *sqlwtest.Node) {
+		n.Latency = 20 * time.Millisecond
+	})
+
+	for i := 0; i < 2; i++ {
+		if _, err := db.Exec(context.Background(), "DELETE FROM users"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := breakerOf(db, "master"); got != sqlw.BreakerOpen {
+		t.Errorf("breaker should be open but got: %s", got)
+	}
+	// The master is never removed from the selection
+	if _, err := db.Exec(context.Background(), "DELETE FROM users"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDBSetBreakerPolicyHealth(t *testing.T) {
+	c := sqlwtest.NewCluster(t)
+	db := sqlw.NewDB(c.Open(t, "master"), c.Open(t, "replica0"))
+	p := sqlw.DefaultBreakerPolicy
+	p.OpenTimeout = time.Hour
+	db.SetBreakerPolicy(&p)
+	ctx := context.Background()
+
+	c.Update("replica0", func(n *sqlwtest.Node) {
+		n.Down = true
+	})
+	watchHealthOnce(t, db)
+	if got := breakerOf(db, "replica0"); got != sqlw.BreakerOpen {
+		t.Fatalf("breaker should be open but got: %s", got)
+	}
+
+	c.Update("replica0", func(n *sqlwtest.Node) {
+		n.Down = false
+	})
+	watchHealthOnce(t, db)
+	if got := breakerOf(db, "replica0"); got != sqlw.BreakerHalfOpen {
+		t.Fatalf("breaker should be half-open but got: %s", got)
+	}
+	for i := 0; i < p.HalfOpenRequests; i++ {
+		rows, err := db.Query(ctx, "SELECT * FROM users")
+		if err != nil {
+			t.Fatal(err)
+		}
+		rows.Close()
+	}
+	if got := breakerOf(db, "replica0"); got != sqlw.BreakerClosed {
+		t.Errorf("breaker should be closed but got: %s", got)
+	}
+}
+
+// watchHealthOnce checks the health of the nodes once by WatchHealth.
+func watchHealthOnce(t *testing.T, db *sqlw.DB) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := db.WatchHealth(ctx, time.Hour); err != context.DeadlineExceeded {
+		t.Fatalf("should be error of %v but got: %v", context.DeadlineExceeded, err)
+	}
+}
@@ -0,0 +1,99 @@
+package sqlw
+
+import (
+	"context"
+	"net/url"
+	"sort"
+	"strings"
+	"sync/atomic"
+)
+
+// CommentTagger returns the tags of the SQL comment from the context, such as traceparent of the span.
+type CommentTagger func(ctx context.Context) map[string]string
+
+type commentTagsKey struct{}
+
+// WithCommentTag returns a new context with the tag of the SQL comment.
+func WithCommentTag(ctx context.Context, key, value string) context.Context {
+	tags := map[string]string{}
+	if parent, ok := ctx.Value(commentTagsKey{}).(map[string]string); ok {
+		for k, v := range parent {
+			tags[k] = v
+		}
+	}
+	tags[key] = value
+	return context.WithValue(ctx, commentTagsKey{}, tags)
+}
+
+// SetSQLComment sets whether the SQL comment is appended to the statements,
+// like `SELECT * FROM users /*route='%2Fusers',service='api'*/`.
+// The comment follows the sqlcommenter format and is built from the tags set by WithCommentTag and the comment taggers.
+//
+// The comment is appended after the statement is validated, and the hooks receive the statement without the comment.
+// The prepared statements are not commented so that they can be reused by any callers,
+// and the statements that already have comments are left as they are.
+func (db *DB) SetSQLComment(enabled bool) {
+	var v int32
+	if enabled {
+		v = 1
+	}
+	atomic.StoreInt32(&db.sqlComment, v)
+}
+
+// AddCommentTagger adds the comment tagger.
+// The tags of the taggers are overridden by the tags set by WithCommentTag.
+//
+// This function should be used outside of Goroutine.
+func (db *DB) AddCommentTagger(t CommentTagger) {
+	db.taggers = append(db.taggers, t)
+}
+
+// comment appends the SQL comment built from the context to the statement.
+func (db *DB) comment(ctx context.Context, query string) string {
+	if atomic.LoadInt32(&db.sqlComment) == 0 {
+		return query
+	}
+	tags := map[string]string{}
+	for _, t := range db.taggers {
+		for k, v := range t(ctx) {
+			tags[k] = v
+		}
+	}
+	if ctxTags, ok := ctx.Value(commentTagsKey{}).(map[string]string); ok {
+		for k, v := range ctxTags {
+			tags[k] = v
+		}
+	}
+	if len(tags) == 0 || hasComment(query) {
+		return query
+	}
+
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	list := []string{}
+	for _, k := range keys {
+		list = append(list, commentEscape(k)+"='"+strings.ReplaceAll(commentEscape(tags[k]), "'", `\'`)+"'")
+	}
+
+	query = strings.TrimRightFunc(query, func(r rune) bool {
+		return r == ';' || r == ' ' || r == '\t' || r == '\n' || r == '\r'
+	})
+	return query + " /*" + strings.Join(list, ",") + "*/"
+}
+
+// commentEscape encodes the key or the value of the tag by the URL encoding.
+func commentEscape(s string) string {
+	return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+}
+
+func hasComment(query string) bool {
+	for _, t := range tokenize(query) {
+		if t.kind == tokenComment {
+			return true
+		}
+	}
+	return false
+}
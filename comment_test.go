@@ -0,0 +1,68 @@
+package sqlw_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/glassonion1/sqlw"
+	"github.com/glassonion1/sqlw/sqlwtest"
+)
+
+func TestDBSetSQLComment(t *testing.T) {
+	c := sqlwtest.NewCluster(t)
+	db := sqlw.NewDB(c.Open(t, "master"))
+	db.SetSQLComment(true)
+	db.AddCommentTagger(func(ctx context.Context) map[string]string {
+		return map[string]string{"service": "api", "route": "unknown"}
+	})
+	r := &hookRecorder{}
+	db.Use(r)
+
+	ctx := sqlw.WithCommentTag(context.Background(), "route", "/items")
+	ctx = sqlw.WithCommentTag(ctx, "note", "it's")
+	if _, err := db.Exec(ctx, "DELETE FROM items;"); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := db.QueryForMaster(ctx, "SELECT * FROM items /* keep */")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows.Close()
+	stmt, err := db.PrepareMutation(ctx, "UPDATE items SET name = ?")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stmt.Close()
+	err = db.Transaction(ctx, func(ctx context.Context, tx *sqlw.Tx) error {
+		_, err := tx.Exec(ctx, "DELETE FROM users")
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		`DELETE FROM items /*note='it%27s',route='%2Fitems',service='api'*/`,
+		"SELECT * FROM items /* keep */",
+		"PREPARE UPDATE items SET name = ?",
+		"BEGIN",
+		`DELETE FROM users /*note='it%27s',route='%2Fitems',service='api'*/`,
+		"COMMIT",
+	}
+	if diff := cmp.Diff(c.Stmts("master"), want); diff != "" {
+		t.Errorf("failed to comment: %v", diff)
+	}
+	if got := r.summary()[0]; got != "exec DELETE FROM items;@master(master)" {
+		t.Errorf("hooks should receive the statement without the comment: %v", got)
+	}
+
+	db.SetSQLComment(false)
+	if _, err := db.Exec(ctx, "DELETE FROM items"); err != nil {
+		t.Fatal(err)
+	}
+	if got := c.Stmts("master"); got[len(got)-1] != "DELETE FROM items" {
+		t.Errorf("should not comment: %v", got)
+	}
+}
@@ -8,98 +8,365 @@ import (
 	"math/rand"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	_ "github.com/go-sql-driver/mysql"
 )
 
+// The following errors are returned when changing the topology of the database.
+var (
+	ErrNodeExists   = errors.New("node already exists")
+	ErrNodeNotFound = errors.New("node not found")
+	ErrNoConnector  = errors.New("connector is not set")
+)
+
 // DB is a wrapper around sql.DB
 type DB struct {
-	master       *sql.DB
-	readreplicas []*sql.DB
-	mu           sync.Mutex
+	master       *node
+	readreplicas []*node
+	candidates   []*node
+	dialect      Dialect
+	connector    Connector
+	poolOpts     []func(*sql.DB)
+	handlers     []EventHandler
+	hooks        []Hook
+	taggers      []CommentTagger
+	quorum       ReadQuorum
+	maxLag       time.Duration
+	readTimeout  time.Duration
+	readRetries  int32
+	writeTimeout time.Duration
+	txTimeout    time.Duration
+	slow         *slowLog
+	hedge        *hedger
+	// breakerPolicy is *BreakerPolicy.
+	breakerPolicy atomic.Value
+	// limitPolicy is *LimitPolicy.
+	limitPolicy atomic.Value
+	// readFromMaster is 1 if the queries for the replicas are executed on the master.
+	readFromMaster int32
+	// sqlComment is 1 if the SQL comment is appended to the statements.
+	sqlComment int32
+	// stmtCacheSize is the max number of the cached statements on each node.
+	stmtCacheSize int32
+	// panicAsError is 1 if the panic in the transaction is returned as *PanicError.
+	panicAsError int32
+	// maxTxAge is the max age of the transactions begun by Begin.
+	maxTxAge int64
+	// txs are the open transactions begun by Begin.
+	txs        map[*Tx]struct{}
+	txMu       sync.Mutex
+	logger     Logger
+	topoMu     sync.RWMutex
+	failoverMu sync.Mutex
+	// detecting is the detection of the master in progress, which is shared by the concurrent calls of DetectMaster.
+	detecting *detectCall
+	timeoutMu sync.Mutex
+	mu        sync.Mutex
+}
+
+// Executor is the interface of the queries, the mutations and the transactions of DB.
+// The repositories depending on it can be tested with the handle of sqlwtest.WithRollback instead of DB.
+type Executor interface {
+	Query(ctx context.Context, query SQLQuery, args ...interface{}) (*sql.Rows, error)
+	QueryForMaster(ctx context.Context, query SQLQuery, args ...interface{}) (*sql.Rows, error)
+	QueryRow(ctx context.Context, query SQLQuery, args ...interface{}) *sql.Row
+	QueryRowForMaster(ctx context.Context, query SQLQuery, args ...interface{}) *sql.Row
+	Exec(ctx context.Context, query SQLMutation, args ...interface{}) (sql.Result, error)
+	Transaction(ctx context.Context, fn TxHandlerFunc) error
+	TransactionTx(ctx context.Context, fn TxHandlerFunc, opts *sql.TxOptions) error
 }
 
+var _ Executor = (*DB)(nil)
+
 // NewMySQLDB returns a new sqlx DB wrapper for a pre-existing *sql.DB
 //
 // This function should be used outside of Goroutine.
 func NewMySQLDB(masterConf Config, replicaConfs ...Config) (*DB, error) {
-	master, err := sql.Open("mysql", masterConf.mysqlStr())
-	if err != nil {
-		return nil, err
-	}
-
-	replicas := []*sql.DB{}
-	for _, conf := range replicaConfs {
-		r, err := sql.Open("mysql", conf.mysqlStr())
-		if err != nil {
-			continue
-		}
-		if err := r.Ping(); err != nil {
-			continue
-		}
-		replicas = append(replicas, r)
-	}
-	return NewDB(master, replicas...), nil
+	return newDBWithConnector(MySQLConnector, masterConf, replicaConfs...)
 }
 
 // NewPostgresDB returns a new sqlx DB wrapper for a pre-existing *sql.DB
 //
 // This function should be used outside of Goroutine.
 func NewPostgresDB(masterConf Config, replicaConfs ...Config) (*DB, error) {
-	master, err := sql.Open("postgres", masterConf.postgresStr())
+	db, err := newDBWithConnector(PostgresConnector, masterConf, replicaConfs...)
+	if err != nil {
+		return nil, err
+	}
+	db.dialect = DialectPostgres
+	return db, nil
+}
+
+func newDBWithConnector(connector Connector, masterConf Config, replicaConfs ...Config) (*DB, error) {
+	master, err := connector(masterConf)
 	if err != nil {
 		return nil, err
 	}
 
-	replicas := []*sql.DB{}
+	db := NewDB(master)
+	db.master.name = masterConf.name()
+	db.master.conf = &masterConf
+	db.connector = connector
 	for _, conf := range replicaConfs {
-		r, err := sql.Open("postgres", conf.postgresStr())
+		r, err := connector(conf)
 		if err != nil {
 			continue
 		}
 		if err := r.Ping(); err != nil {
 			continue
 		}
-		replicas = append(replicas, r)
+		if err := db.addReplica(conf.name(), r, &conf); err != nil {
+			r.Close()
+		}
 	}
-	return NewDB(master, replicas...), nil
+	return db, nil
 }
 
 // NewDB returns a new sqlx DB wrapper for a pre-existing *sql.DB
 //
+// The master is named "master" and the replicas are named "replica0", "replica1" and so on.
 // This function should be used outside of Goroutine.
 func NewDB(master *sql.DB, readreplicas ...*sql.DB) *DB {
 	rand.Seed(time.Now().UnixNano())
 
-	list := []*sql.DB{}
+	list := []*node{}
 	for _, r := range readreplicas {
 		if r != nil {
-			list = append(list, r)
+			list = append(list, newNode(fmt.Sprintf("replica%d", len(list)), r))
 		}
 	}
 	return &DB{
-		master:       master,
+		master:       newNode("master", master),
 		readreplicas: list,
+		dialect:      detectDialect(master),
+		slow:         newSlowLog(),
+		hedge:        &hedger{},
+	}
+}
+
+// SetDialect sets the dialect of the database.
+// NewDB detects the dialect from the driver and falls back to MySQL.
+//
+// This function should be used outside of Goroutine.
+func (db *DB) SetDialect(d Dialect) {
+	db.dialect = d
+}
+
+// SetConnector sets the connector used to open the databases added by the topology provider.
+// NewMySQLDB and NewPostgresDB set the connector automatically.
+func (db *DB) SetConnector(c Connector) {
+	db.topoMu.Lock()
+	defer db.topoMu.Unlock()
+	db.connector = c
+}
+
+func (db *DB) getMaster() *node {
+	db.topoMu.RLock()
+	defer db.topoMu.RUnlock()
+	return db.master
+}
+
+// getReplica returns a healthy replica at random, or the master if no replicas are healthy.
+func (db *DB) getReplica() *node {
+	return db.getReplicaExcept(nil)
+}
+
+// getReplicaExcept returns a healthy replica at random except the nodes, or the master if no replicas are left.
+// It returns nil if the master is also excluded.
+func (db *DB) getReplicaExcept(except []*node) *node {
+	db.topoMu.RLock()
+	defer db.topoMu.RUnlock()
+
+	master := db.master
+	if containsNode(except, master) {
+		master = nil
+	}
+	if db.ReadFromMaster() {
+		return master
+	}
+	policy := db.getBreakerPolicy()
+	now := time.Now()
+	healthy := make([]*node, 0, len(db.readreplicas))
+	for _, r := range db.readreplicas {
+		if r.isHealthy() && !r.isDrained() && !containsNode(except, r) && r.breaker.ready(policy, now) {
+			healthy = append(healthy, r)
+		}
+	}
+	if len(healthy) == 0 {
+		return master
+	}
+	return healthy[rand.Intn(len(healthy))]
+}
+
+// nodes returns the master and the replicas.
+func (db *DB) nodes() (*node, []*node) {
+	db.topoMu.RLock()
+	defer db.topoMu.RUnlock()
+	replicas := make([]*node, len(db.readreplicas))
+	copy(replicas, db.readreplicas)
+	return db.master, replicas
+}
+
+// hasNode reports whether the database has the node named the name.
+// The caller must hold topoMu.
+func (db *DB) hasNode(name string) bool {
+	if db.master.name == name {
+		return true
+	}
+	for _, n := range db.readreplicas {
+		if n.name == name {
+			return true
+		}
+	}
+	for _, n := range db.candidates {
+		if n.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// contains reports whether the node is the master, a replica or a master candidate of the database.
+func (db *DB) contains(n *node) bool {
+	db.topoMu.RLock()
+	defer db.topoMu.RUnlock()
+	return db.master == n || containsNode(db.readreplicas, n) || containsNode(db.candidates, n)
+}
+
+// AddReplica adds the read replica to the database with the name.
+// It is safe for concurrent use.
+func (db *DB) AddReplica(name string, replica *sql.DB) error {
+	return db.addReplica(name, replica, nil)
+}
+
+func (db *DB) addReplica(name string, replica *sql.DB, conf *Config) error {
+	db.topoMu.Lock()
+	defer db.topoMu.Unlock()
+
+	if db.hasNode(name) {
+		return fmt.Errorf("failed to add %s: %w", name, ErrNodeExists)
+	}
+	for _, opt := range db.poolOpts {
+		opt(replica)
+	}
+	n := newNode(name, replica)
+	n.conf = conf
+	db.readreplicas = append(db.readreplicas, n)
+	return nil
+}
+
+// RemoveReplica removes the read replica from the database.
+// The replica stops receiving new queries at once, and is closed after the in-flight queries finish or the context is done.
+// It is safe for concurrent use.
+func (db *DB) RemoveReplica(ctx context.Context, name string) error {
+	removed, err := db.removeReplica(name)
+	if err != nil {
+		return err
+	}
+	return removed.drain(ctx)
+}
+
+// removeReplica removes the read replica from the selection and returns it to be drained.
+func (db *DB) removeReplica(name string) (*node, error) {
+	db.topoMu.Lock()
+	var removed *node
+	list := make([]*node, 0, len(db.readreplicas))
+	for _, r := range db.readreplicas {
+		if r.name == name {
+			removed = r
+			continue
+		}
+		list = append(list, r)
+	}
+	db.readreplicas = list
+	db.topoMu.Unlock()
+
+	if removed == nil {
+		return nil, fmt.Errorf("failed to remove %s: %w", name, ErrNodeNotFound)
+	}
+	return removed, nil
+}
+
+// ReplaceMaster replaces the master with the new one named the name.
+// The old master is closed after the in-flight queries finish or the context is done.
+// It is safe for concurrent use.
+func (db *DB) ReplaceMaster(ctx context.Context, name string, master *sql.DB) error {
+	old, err := db.replaceMaster(name, master, nil)
+	if err != nil {
+		return err
 	}
+	return old.drain(ctx)
 }
 
-func (db *DB) getReplica() *sql.DB {
-	if len(db.readreplicas) == 0 {
-		return db.master
+// replaceMaster replaces the master and returns the old one to be drained.
+func (db *DB) replaceMaster(name string, master *sql.DB, conf *Config) (*node, error) {
+	db.topoMu.Lock()
+	if db.hasNode(name) {
+		db.topoMu.Unlock()
+		return nil, fmt.Errorf("failed to replace master with %s: %w", name, ErrNodeExists)
 	}
-	return db.readreplicas[rand.Intn(len(db.readreplicas))]
+	for _, opt := range db.poolOpts {
+		opt(master)
+	}
+	old := db.master
+	db.master = newNode(name, master)
+	db.master.conf = conf
+	db.topoMu.Unlock()
+
+	return old, nil
+}
+
+// reopen replaces the master or the replica named the name with the database opened with the new config,
+// and returns the old one to be drained.
+func (db *DB) reopen(name string, sdb *sql.DB, conf Config) (*node, error) {
+	db.topoMu.Lock()
+	n := newNode(name, sdb)
+	n.conf = &conf
+	var old *node
+	if db.master.name == name {
+		old = db.master
+		db.master = n
+	} else {
+		list := make([]*node, len(db.readreplicas))
+		for i, r := range db.readreplicas {
+			list[i] = r
+			if r.name == name {
+				old = r
+				list[i] = n
+			}
+		}
+		db.readreplicas = list
+	}
+	if old == nil {
+		db.topoMu.Unlock()
+		return nil, fmt.Errorf("failed to reopen %s: %w", name, ErrNodeNotFound)
+	}
+	for _, opt := range db.poolOpts {
+		opt(sdb)
+	}
+	// Keeps the node drained by the operator.
+	n.drained = atomic.LoadInt32(&old.drained)
+	db.topoMu.Unlock()
+
+	return old, nil
 }
 
 // Close closes all databases.
 func (db *DB) Close() error {
+	master, replicas := db.nodes()
+	db.topoMu.RLock()
+	replicas = append(replicas, db.candidates...)
+	db.topoMu.RUnlock()
+
 	errList := []string{}
-	if err := db.master.Close(); err != nil {
+	if err := master.db.Close(); err != nil {
 		errList = append(errList, err.Error())
 	}
 
-	for _, r := range db.readreplicas {
-		if rerr := r.Close(); rerr != nil {
+	for _, r := range replicas {
+		if rerr := r.db.Close(); rerr != nil {
 			errList = append(errList, rerr.Error())
 		}
 	}
@@ -110,56 +377,79 @@ func (db *DB) Close() error {
 	return nil
 }
 
-// SetConnMaxLifetime sets the maximum amount of time a connection may be reused.
-func (db *DB) SetConnMaxLifetime(d time.Duration) {
-	db.master.SetConnMaxLifetime(d)
+// setPoolOpt applies the pool setting to all databases, including the ones added later.
+func (db *DB) setPoolOpt(opt func(*sql.DB)) {
+	db.topoMu.Lock()
+	defer db.topoMu.Unlock()
+
+	db.poolOpts = append(db.poolOpts, opt)
+	opt(db.master.db)
 	for _, r := range db.readreplicas {
-		r.SetConnMaxLifetime(d)
+		opt(r.db)
+	}
+	for _, c := range db.candidates {
+		opt(c.db)
 	}
 }
 
+// SetConnMaxLifetime sets the maximum amount of time a connection may be reused.
+func (db *DB) SetConnMaxLifetime(d time.Duration) {
+	db.setPoolOpt(func(sdb *sql.DB) {
+		sdb.SetConnMaxLifetime(d)
+	})
+}
+
 // SetMaxIdleConns sets the maximum number of connections in the idle connection pool.
 func (db *DB) SetMaxIdleConns(n int) {
-	db.master.SetMaxIdleConns(n)
-	for _, r := range db.readreplicas {
-		r.SetMaxIdleConns(n)
-	}
+	db.setPoolOpt(func(sdb *sql.DB) {
+		sdb.SetMaxIdleConns(n)
+	})
 }
 
 // SetMaxOpenConns sets the maximum number of open connections to the database.
 func (db *DB) SetMaxOpenConns(n int) {
-	db.master.SetMaxOpenConns(n)
-	for _, r := range db.readreplicas {
-		r.SetMaxOpenConns(n)
-	}
+	db.setPoolOpt(func(sdb *sql.DB) {
+		sdb.SetMaxOpenConns(n)
+	})
 }
 
 // Readable checks if the database can be readable.
+// The database is readable if the health report satisfies the read quorum, see SetReadQuorum.
 func (db *DB) Readable() error {
-	errList := []string{}
-
-	if err := db.master.Ping(); err != nil {
-		errList = append(errList, fmt.Sprintf("failed to ping master: %v", err))
-	}
-
-	for i, r := range db.readreplicas {
-		if err := r.Ping(); err != nil {
-			str := fmt.Sprintf("failed to ping replica%d: %v", i, err)
-			errList = append(errList, str)
-		}
-	}
+	return db.ReadableContext(context.Background())
+}
 
-	if len(errList) > 0 {
-		str := strings.Join(errList, ",")
-		return errors.New(str)
+// ReadableContext checks if the database can be readable with the context, such as the context of the health check request.
+func (db *DB) ReadableContext(ctx context.Context) error {
+	db.topoMu.RLock()
+	quorum := db.quorum
+	db.topoMu.RUnlock()
+	if quorum == nil {
+		quorum = QuorumAll
 	}
-
-	return nil
+	return quorum(db.Health(ctx))
 }
 
 // Writable checks if the database is writable.
+// The database is writable if the master answers and is not read only.
 func (db *DB) Writable() error {
-	return db.master.Ping()
+	return db.WritableContext(context.Background())
+}
+
+// WritableContext checks if the database is writable with the context, such as the context of the health check request.
+func (db *DB) WritableContext(ctx context.Context) error {
+	master := db.getMaster()
+	if err := master.db.PingContext(ctx); err != nil {
+		return err
+	}
+	readOnly, err := db.isReadOnly(ctx, master)
+	if err != nil {
+		return err
+	}
+	if readOnly {
+		return fmt.Errorf("%s: %w", master.name, ErrReadOnly)
+	}
+	return nil
 }
 
 // Query executes a query that returns rows, typically a SELECT.
@@ -168,7 +458,11 @@ func (db *DB) Query(ctx context.Context, query SQLQuery, args ...interface{}) (*
 	if err := query.Validate(); err != nil {
 		return nil, err
 	}
-	return db.getReplica().QueryContext(ctx, query.String(), args...)
+	v, err := db.withReplica(ctx, query.String(), func(ctx context.Context, n *node) (interface{}, error) {
+		return db.query(ctx, n, query.String(), args...)
+	})
+	rows, _ := v.(*sql.Rows)
+	return rows, err
 }
 
 // QueryForMaster executes a query that returns rows, typically a SELECT.
@@ -179,34 +473,46 @@ func (db *DB) QueryForMaster(ctx context.Context, query SQLQuery, args ...interf
 	if err := query.Validate(); err != nil {
 		return nil, err
 	}
-	return db.master.QueryContext(ctx, query.String(), args...)
+	var rows *sql.Rows
+	err := db.withMaster(ctx, true, func(ctx context.Context, n *node) error {
+		var err error
+		rows, err = db.query(ctx, n, query.String(), args...)
+		return err
+	})
+	return rows, err
 }
 
-// QueryRow executes a query that is expected to return at most one row. QueryRow always returns a non-nil value. Errors are deferred until Row's Scan method is called. If the query selects no rows, the *Row's Scan will return ErrNoRows. Otherwise, the *Row's Scan scans the first selected row and discards the rest.
+// QueryRow executes a query that is expected to return at most one row. QueryRow returns nil only if the query is invalid. Errors are deferred until Row's Scan method is called, including the errors rejecting the query before it is sent, such as the errors of the hooks. If the query selects no rows, the *Row's Scan will return ErrNoRows. Otherwise, the *Row's Scan scans the first selected row and discards the rest.
 // This method is executed on the read replica.
 func (db *DB) QueryRow(ctx context.Context, query SQLQuery, args ...interface{}) *sql.Row {
 	if err := query.Validate(); err != nil {
 		return nil
 	}
-	return db.getReplica().QueryRowContext(ctx, query.String(), args...)
+	v, err := db.withReplica(ctx, query.String(), func(ctx context.Context, n *node) (interface{}, error) {
+		row := db.queryRow(ctx, n, query.String(), args...)
+		return row, row.Err()
+	})
+	if row, ok := v.(*sql.Row); ok {
+		return row
+	}
+	return errRow(err)
 }
 
-// QueryRowForMaster executes a query that is expected to return at most one row. QueryRow always returns a non-nil value. Errors are deferred until Row's Scan method is called. If the query selects no rows, the *Row's Scan will return ErrNoRows. Otherwise, the *Row's Scan scans the first selected row and discards the rest.
+// QueryRowForMaster executes a query that is expected to return at most one row. QueryRowForMaster returns nil only if the query is invalid. Errors are deferred until Row's Scan method is called, including the errors rejecting the query before it is sent, such as the errors of the hooks. If the query selects no rows, the *Row's Scan will return ErrNoRows. Otherwise, the *Row's Scan scans the first selected row and discards the rest.
 // This method is executed on the master.
 func (db *DB) QueryRowForMaster(ctx context.Context, query SQLQuery, args ...interface{}) *sql.Row {
 	if err := query.Validate(); err != nil {
 		return nil
 	}
-	return db.master.QueryRowContext(ctx, query.String(), args...)
-}
-
-// PrepareQuery creates a prepared statement for later queries.The caller must call the statement's Close method when the statement is no longer needed.
-// This method is executed on the read replica and can use for SELECT statements only.
-func (db *DB) PrepareQuery(ctx context.Context, query SQLQuery) (*sql.Stmt, error) {
-	if err := query.Validate(); err != nil {
-		return nil, err
+	var row *sql.Row
+	err := db.withMaster(ctx, true, func(ctx context.Context, n *node) error {
+		row = db.queryRow(ctx, n, query.String(), args...)
+		return row.Err()
+	})
+	if row == nil {
+		return errRow(err)
 	}
-	return db.getReplica().PrepareContext(ctx, query.String())
+	return row
 }
 
 // PrepareQueryForMaster creates a prepared statement for later queries(SELECT).The caller must call the statement's Close method when the statement is no longer needed.
@@ -215,7 +521,13 @@ func (db *DB) PrepareQueryForMaster(ctx context.Context, query SQLQuery) (*sql.S
 	if err := query.Validate(); err != nil {
 		return nil, err
 	}
-	return db.master.PrepareContext(ctx, query.String())
+	var stmt *sql.Stmt
+	err := db.withMaster(ctx, true, func(ctx context.Context, n *node) error {
+		var err error
+		stmt, err = db.prepare(ctx, n, query.String())
+		return err
+	})
+	return stmt, err
 }
 
 // PrepareMutation creates a prepared statement for later executions.The caller must call the statement's Close method when the statement is no longer needed.
@@ -224,7 +536,13 @@ func (db *DB) PrepareMutation(ctx context.Context, query SQLMutation) (*sql.Stmt
 	if err := query.Validate(); err != nil {
 		return nil, err
 	}
-	return db.master.PrepareContext(ctx, query.String())
+	var stmt *sql.Stmt
+	err := db.withMaster(ctx, true, func(ctx context.Context, n *node) error {
+		var err error
+		stmt, err = db.prepare(ctx, n, query.String())
+		return err
+	})
+	return stmt, err
 }
 
 // Exec executes a query without returning any rows. The args are for any placeholder parameters in the query.
@@ -233,51 +551,207 @@ func (db *DB) Exec(ctx context.Context, query SQLMutation, args ...interface{})
 	if err := query.Validate(); err != nil {
 		return nil, err
 	}
-	return db.master.ExecContext(ctx, query.String(), args...)
+	var res sql.Result
+	err := db.withMaster(ctx, false, func(ctx context.Context, n *node) error {
+		var err error
+		res, err = db.exec(ctx, n, query.String(), args...)
+		return err
+	})
+	return res, err
 }
 
-// Transaction executes paramed function in one database transaction. Executes the passed function and commits the transaction if there is no error. If an error occurs when executing the passed function rolls back the transaction.
-// see sqlw/TxHandlerFunc
-func (db *DB) Transaction(ctx context.Context, fn TxHandlerFunc) error {
-	db.mu.Lock()
-	defer db.mu.Unlock()
+func (db *DB) query(ctx context.Context, n *node, query string, args ...interface{}) (*sql.Rows, error) {
+	var rows *sql.Rows
+	info := &QueryInfo{Op: OpQuery, Query: query, Args: args}
+	err := db.run(ctx, info, n, func(ctx context.Context) error {
+		e, done, err := db.execerOn(ctx, n, query)
+		if err != nil {
+			return err
+		}
+		defer done()
+		rows, err = e.QueryContext(ctx, args...)
+		return err
+	})
+	return rows, err
+}
 
-	origin, err := db.master.BeginTx(ctx, nil)
-	if err != nil {
-		return fmt.Errorf("failed to begin transaction: %v", err)
+func (db *DB) queryRow(ctx context.Context, n *node, query string, args ...interface{}) *sql.Row {
+	var row *sql.Row
+	info := &QueryInfo{Op: OpQuery, Query: query, Args: args}
+	err := db.run(ctx, info, n, func(ctx context.Context) error {
+		e, done, err := db.execerOn(ctx, n, query)
+		if err != nil {
+			return err
+		}
+		defer done()
+		row = e.QueryRowContext(ctx, args...)
+		return row.Err()
+	})
+	if row == nil {
+		return errRow(err)
 	}
-	tx := &Tx{origin}
+	return row
+}
 
-	if err := fn(ctx, tx); err != nil {
-		if re := tx.parent.Rollback(); re != nil {
-			if re.Error() != sql.ErrTxDone.Error() {
-				return fmt.Errorf("fialed to rollback: %v", err)
+func (db *DB) prepare(ctx context.Context, n *node, query string) (*sql.Stmt, error) {
+	var stmt *sql.Stmt
+	info := &QueryInfo{Op: OpPrepare, Query: query}
+	err := db.run(ctx, info, n, func(ctx context.Context) error {
+		var err error
+		stmt, err = n.db.PrepareContext(ctx, query)
+		return err
+	})
+	return stmt, err
+}
+
+func (db *DB) exec(ctx context.Context, n *node, query string, args ...interface{}) (sql.Result, error) {
+	var res sql.Result
+	info := &QueryInfo{Op: OpExec, Query: query, Args: args}
+	err := db.run(ctx, info, n, func(ctx context.Context) error {
+		e, done, err := db.execerOn(ctx, n, query)
+		if err != nil {
+			return err
+		}
+		defer done()
+		res, err = e.ExecContext(ctx, args...)
+		if err == nil {
+			if affected, aerr := res.RowsAffected(); aerr == nil {
+				info.RowsAffected = affected
 			}
 		}
-		return fmt.Errorf("failed to execcute transaction: %v", err)
-	}
-	return tx.parent.Commit()
+		return err
+	})
+	return res, err
+}
+
+// Transaction executes paramed function in one database transaction. Executes the passed function and commits the transaction if there is no error. If an error occurs when executing the passed function rolls back the transaction.
+// see sqlw/TxHandlerFunc
+func (db *DB) Transaction(ctx context.Context, fn TxHandlerFunc) error {
+	return db.TransactionTx(ctx, fn, nil)
 }
 
 // TransactionTx executes paramed function in one database transaction. Executes the passed function and commits the transaction if there is no error. If an error occurs when executing the passed function rolls back the transaction.
 // see sqlw/TxHandlerFunc
+//
+// The transaction with sql.TxOptions{ReadOnly: true} is executed on the read replica, and Tx.Exec returns ErrReadOnlyTx in it.
+// If the isolation level is sql.LevelSnapshot, it is started by START TRANSACTION READ ONLY, WITH CONSISTENT SNAPSHOT on MySQL,
+// and by the repeatable read isolation level on PostgreSQL.
+//
+// The functions registered by Tx.AfterCommit and Tx.AfterRollback are called after the transaction ends.
+// If the function panics or calls runtime.Goexit, the transaction is rolled back before the panic propagates.
 func (db *DB) TransactionTx(ctx context.Context, fn TxHandlerFunc, opts *sql.TxOptions) error {
-	db.mu.Lock()
-	defer db.mu.Unlock()
+	var tx *Tx
+	err := db.runTransaction(ctx, func(ctx context.Context, t *Tx) error {
+		tx = t
+		return fn(ctx, t)
+	}, opts)
+	if tx != nil {
+		tx.runCallbacks(ctx, err)
+	}
+	db.repanic(err)
+	return err
+}
 
-	origin, err := db.master.BeginTx(ctx, opts)
+// runTransaction executes the transaction under the lock.
+// The callbacks of the transaction are called by the caller after the lock is released,
+// so that they can begin another transaction.
+func (db *DB) runTransaction(ctx context.Context, fn TxHandlerFunc, opts *sql.TxOptions) error {
+	n := db.getMaster()
+	if opts != nil && opts.ReadOnly {
+		n = db.getReplica()
+	} else {
+		// The read only transactions on the replicas are not serialized with the transactions on the master.
+		db.mu.Lock()
+		defer db.mu.Unlock()
+	}
+	info := &QueryInfo{Op: OpTransaction}
+	return db.run(ctx, info, n, func(ctx context.Context) error {
+		return db.transaction(ctx, n, fn, opts)
+	})
+}
+
+func (db *DB) transaction(ctx context.Context, n *node, fn TxHandlerFunc, opts *sql.TxOptions) error {
+	tx, err := db.begin(ctx, n, opts)
 	if err != nil {
 		return fmt.Errorf("failed to begin transaction: %v", err)
 	}
-	tx := &Tx{origin}
+	tx.node.acquire()
+	defer tx.node.release()
+
+	if err := callTx(ctx, tx, fn); err != nil {
+		re := tx.rollback(ctx)
+		db.detectMasterOnTx(ctx, err, tx)
+		if perr, ok := err.(*PanicError); ok {
+			return perr
+		}
+		if re != nil && re.Error() != sql.ErrTxDone.Error() {
+			return fmt.Errorf("fialed to rollback: %v", err)
+		}
+		return fmt.Errorf("failed to execcute transaction: %v", err)
+	}
+	if err := tx.commit(ctx); err != nil {
+		db.detectMasterOnTx(ctx, err, tx)
+		return err
+	}
+	return nil
+}
+
+// begin begins the transaction.
+// The read only transaction begins on the node, and the others begin on the master.
+func (db *DB) begin(ctx context.Context, n *node, opts *sql.TxOptions) (*Tx, error) {
+	if opts != nil && opts.ReadOnly {
+		return db.beginOn(ctx, n, opts)
+	}
+	var tx *Tx
+	err := db.withMaster(ctx, true, func(ctx context.Context, n *node) error {
+		var err error
+		tx, err = db.beginOn(ctx, n, opts)
+		return err
+	})
+	return tx, err
+}
 
-	if err := fn(ctx, tx); err != nil {
-		if re := tx.parent.Rollback(); re != nil {
-			if re.Error() != sql.ErrTxDone.Error() {
-				return fmt.Errorf("fialed to rollback: %v", err)
+func (db *DB) beginOn(ctx context.Context, n *node, opts *sql.TxOptions) (*Tx, error) {
+	var tx *Tx
+	info := &QueryInfo{Op: OpBegin}
+	err := db.run(ctx, info, n, func(ctx context.Context) error {
+		readOnly := opts != nil && opts.ReadOnly
+		if opts == nil || opts.Isolation != sql.LevelSnapshot {
+			origin, err := n.db.BeginTx(ctx, opts)
+			if err != nil {
+				return err
 			}
+			tx = &Tx{parent: origin, db: db, node: n, readOnly: readOnly}
+			return nil
 		}
-		return fmt.Errorf("failed to execcute transaction: %v", err)
+
+		q := db.dialect.snapshotQuery()
+		if q == "" || !readOnly {
+			// The repeatable read is the snapshot isolation on PostgreSQL.
+			origin, err := n.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: readOnly})
+			if err != nil {
+				return err
+			}
+			tx = &Tx{parent: origin, db: db, node: n, readOnly: readOnly}
+			return nil
+		}
+		conn, err := n.db.Conn(ctx)
+		if err != nil {
+			return err
+		}
+		if _, err := conn.ExecContext(ctx, q); err != nil {
+			conn.Close()
+			return err
+		}
+		tx = &Tx{parent: &connTx{conn}, db: db, node: n, readOnly: readOnly}
+		return nil
+	})
+	return tx, err
+}
+
+// detectMasterOnTx detects the master if the transaction on the master failed because the master may have failed over.
+func (db *DB) detectMasterOnTx(ctx context.Context, err error, tx *Tx) {
+	if !tx.readOnly {
+		db.detectMasterOn(ctx, err, tx.node)
 	}
-	return tx.parent.Commit()
 }
@@ -0,0 +1,55 @@
+package sqlw
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// Dialect is the kind of the database.
+type Dialect string
+
+// The following dialects are supported.
+const (
+	DialectMySQL    Dialect = "mysql"
+	DialectPostgres Dialect = "postgresql"
+)
+
+// detectDialect detects the dialect from the driver of the database.
+// It falls back to MySQL if the driver is unknown.
+func detectDialect(db *sql.DB) Dialect {
+	if db == nil {
+		return DialectMySQL
+	}
+	name := strings.ToLower(fmt.Sprintf("%T", db.Driver()))
+	if strings.Contains(name, "pq.") || strings.Contains(name, "pgx") || strings.Contains(name, "postgres") {
+		return DialectPostgres
+	}
+	return DialectMySQL
+}
+
+// readOnlyQuery returns the query that selects whether the database is read only.
+func (d Dialect) readOnlyQuery() string {
+	if d == DialectPostgres {
+		return "SELECT pg_is_in_recovery()"
+	}
+	return "SELECT @@global.read_only"
+}
+
+// lagQueries returns the queries that select the replication lag in seconds, the next one is used if the former fails.
+// MySQL 8.4 removed SHOW SLAVE STATUS, and the versions before 8.0.22 do not have SHOW REPLICA STATUS.
+func (d Dialect) lagQueries() []string {
+	if d == DialectPostgres {
+		return []string{"SELECT COALESCE(EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp()), 0) AS lag"}
+	}
+	return []string{"SHOW SLAVE STATUS", "SHOW REPLICA STATUS"}
+}
+
+// snapshotQuery returns the statement that starts the read only transaction with the consistent snapshot.
+// It returns "" if the driver starts it by the isolation level.
+func (d Dialect) snapshotQuery() string {
+	if d == DialectPostgres {
+		return ""
+	}
+	return "START TRANSACTION READ ONLY, WITH CONSISTENT SNAPSHOT"
+}
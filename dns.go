@@ -0,0 +1,103 @@
+package sqlw
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ErrNoAddress is returned when the DNS lookup finds no replicas.
+var ErrNoAddress = errors.New("no address found")
+
+// Resolver looks up the DNS records. *net.Resolver implements it.
+type Resolver interface {
+	LookupHost(ctx context.Context, host string) ([]string, error)
+	LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+}
+
+// DNSTopologyProvider provides the read replicas discovered by the DNS lookup.
+// The master is not included in the topology, so the current master is kept.
+//
+// It is used with DB.WatchTopology to resolve the records on an interval.
+type DNSTopologyProvider struct {
+	template Config
+	host     string
+	service  string
+	proto    string
+	mu       sync.Mutex
+	resolver Resolver
+}
+
+// NewDNSTopologyProvider returns a new provider of the replicas that the host resolves to.
+// The replicas are connected to the port of the template with its credentials.
+func NewDNSTopologyProvider(host string, template Config) *DNSTopologyProvider {
+	return &DNSTopologyProvider{
+		template: template,
+		host:     host,
+		resolver: net.DefaultResolver,
+	}
+}
+
+// NewSRVTopologyProvider returns a new provider of the replicas that the SRV record lists.
+// The replicas are connected to the targets and ports of the record with the credentials of the template.
+func NewSRVTopologyProvider(service, proto, name string, template Config) *DNSTopologyProvider {
+	return &DNSTopologyProvider{
+		template: template,
+		host:     name,
+		service:  service,
+		proto:    proto,
+		resolver: net.DefaultResolver,
+	}
+}
+
+// SetResolver sets the resolver used for the lookup. The default is net.DefaultResolver.
+func (p *DNSTopologyProvider) SetResolver(r Resolver) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.resolver = r
+}
+
+// Topology looks up the records and returns the replicas.
+// It returns ErrNoAddress instead of an empty topology, so that a DNS hiccup does not remove all replicas.
+func (p *DNSTopologyProvider) Topology(ctx context.Context) (Topology, error) {
+	p.mu.Lock()
+	r := p.resolver
+	p.mu.Unlock()
+
+	replicas := []Config{}
+	if p.service != "" || p.proto != "" {
+		_, srvs, err := r.LookupSRV(ctx, p.service, p.proto, p.host)
+		if err != nil {
+			return Topology{}, fmt.Errorf("failed to lookup %s: %v", p.host, err)
+		}
+		for _, srv := range srvs {
+			conf := p.template
+			conf.Host = strings.TrimSuffix(srv.Target, ".")
+			conf.Port = strconv.Itoa(int(srv.Port))
+			replicas = append(replicas, conf)
+		}
+	} else {
+		addrs, err := r.LookupHost(ctx, p.host)
+		if err != nil {
+			return Topology{}, fmt.Errorf("failed to lookup %s: %v", p.host, err)
+		}
+		for _, addr := range addrs {
+			conf := p.template
+			conf.Host = addr
+			replicas = append(replicas, conf)
+		}
+	}
+
+	if len(replicas) == 0 {
+		return Topology{}, fmt.Errorf("failed to lookup %s: %w", p.host, ErrNoAddress)
+	}
+	sort.Slice(replicas, func(i, j int) bool {
+		return replicas[i].name() < replicas[j].name()
+	})
+	return Topology{Replicas: replicas}, nil
+}
@@ -0,0 +1,171 @@
+package sqlw_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/glassonion1/sqlw"
+	"github.com/glassonion1/sqlw/sqlwtest"
+)
+
+// fakeResolver resolves the names from the records.
+type fakeResolver struct {
+	mu    sync.Mutex
+	hosts map[string][]string
+	srvs  map[string][]*net.SRV
+}
+
+func (r *fakeResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	addrs, ok := r.hosts[host]
+	if !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: host}
+	}
+	return addrs, nil
+}
+
+func (r *fakeResolver) LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	srvs, ok := r.srvs[name]
+	if !ok {
+		return "", nil, &net.DNSError{Err: "no such host", Name: name}
+	}
+	return name, srvs, nil
+}
+
+func (r *fakeResolver) setHost(host string, addrs ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.hosts[host] = addrs
+}
+
+func TestDNSTopologyProvider(t *testing.T) {
+	template := sqlw.Config{User: "root", Password: "password", Port: "3306", DBName: "app"}
+	r := &fakeResolver{
+		hosts: map[string][]string{
+			"replica.db.local": {"10.0.0.3", "10.0.0.2"},
+			"empty.db.local":   {},
+		},
+		srvs: map[string][]*net.SRV{
+			"db.local": {
+				{Target: "replica1.db.local.", Port: 3307},
+				{Target: "replica2.db.local.", Port: 3308},
+			},
+		},
+	}
+
+	withHost := func(host, port string) sqlw.Config {
+		conf := template
+		conf.Host = host
+		conf.Port = port
+		return conf
+	}
+
+	tests := []struct {
+		name string
+		in   *sqlw.DNSTopologyProvider
+		want sqlw.Topology
+		err  error
+	}{
+		{
+			name: "resolves the host",
+			in:   sqlw.NewDNSTopologyProvider("replica.db.local", template),
+			want: sqlw.Topology{
+				Replicas: []sqlw.Config{
+					withHost("10.0.0.2", "3306"),
+					withHost("10.0.0.3", "3306"),
+				},
+			},
+		},
+		{
+			name: "resolves the srv record",
+			in:   sqlw.NewSRVTopologyProvider("mysql", "tcp", "db.local", template),
+			want: sqlw.Topology{
+				Replicas: []sqlw.Config{
+					withHost("replica1.db.local", "3307"),
+					withHost("replica2.db.local", "3308"),
+				},
+			},
+		},
+		{
+			name: "resolves no address",
+			in:   sqlw.NewDNSTopologyProvider("empty.db.local", template),
+			err:  sqlw.ErrNoAddress,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			tt.in.SetResolver(r)
+			got, err := tt.in.Topology(context.Background())
+			if !errors.Is(err, tt.err) {
+				t.Errorf("should be error of %v but got: %v", tt.err, err)
+			}
+			if diff := cmp.Diff(got, tt.want); diff != "" {
+				t.Errorf("failed test %s: %v", tt.name, diff)
+			}
+		})
+	}
+}
+
+func TestDBWatchTopologyDNS(t *testing.T) {
+	c := sqlwtest.NewCluster(t)
+	connector := func(conf sqlw.Config) (*sql.DB, error) {
+		return c.Open(t, net.JoinHostPort(conf.Host, conf.Port)), nil
+	}
+	db := sqlw.NewDB(c.Open(t, "master"))
+	db.SetConnector(connector)
+
+	r := &fakeResolver{hosts: map[string][]string{}}
+	r.setHost("replica.db.local", "10.0.0.2", "10.0.0.3")
+	p := sqlw.NewDNSTopologyProvider("replica.db.local", sqlw.Config{Port: "3306"})
+	p.SetResolver(r)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- db.WatchTopology(ctx, p, 10*time.Millisecond)
+	}()
+
+	waitFor := func(want map[string]bool) {
+		t.Helper()
+		deadline := time.Now().Add(time.Second)
+		for {
+			got := queriedNodes(t, db, 50)
+			if cmp.Equal(got, want) {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("failed to discover replicas: %v", cmp.Diff(got, want))
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	waitFor(map[string]bool{"10.0.0.2:3306": true, "10.0.0.3:3306": true})
+
+	r.setHost("replica.db.local", "10.0.0.3", "10.0.0.4")
+	waitFor(map[string]bool{"10.0.0.3:3306": true, "10.0.0.4:3306": true})
+
+	// Keeps the replicas when the lookup finds nothing
+	r.setHost("replica.db.local")
+	time.Sleep(30 * time.Millisecond)
+	waitFor(map[string]bool{"10.0.0.3:3306": true, "10.0.0.4:3306": true})
+
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("should be error of %v but got: %v", context.Canceled, err)
+	}
+}
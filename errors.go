@@ -0,0 +1,110 @@
+package sqlw
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"syscall"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// isConnError reports whether the error is caused by the connection to the database.
+func isConnError(err error) bool {
+	// The timeouts and the cancellations of the context satisfy net.Error, but the connection may be healthy.
+	if err == nil || errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		return false
+	}
+	if errors.Is(err, driver.ErrBadConn) ||
+		errors.Is(err, mysql.ErrInvalidConn) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.ECONNRESET) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	return strings.Contains(err.Error(), "database is closed")
+}
+
+// errRow returns the *sql.Row whose Scan returns the error,
+// which is used when the query is rejected before it is sent to the database.
+func errRow(err error) *sql.Row {
+	db := sql.OpenDB(errConnector{err: err})
+	defer db.Close()
+	return db.QueryRow("")
+}
+
+// errConnector is the driver.Connector failing to connect with the error.
+type errConnector struct {
+	err error
+}
+
+func (c errConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	return nil, c.err
+}
+
+func (c errConnector) Driver() driver.Driver {
+	return c
+}
+
+func (c errConnector) Open(name string) (driver.Conn, error) {
+	return nil, c.err
+}
+
+// isReadOnlyError reports whether the error is caused by writing to the read only database.
+func isReadOnlyError(err error) bool {
+	if err == nil {
+		return false
+	}
+	var myErr *mysql.MySQLError
+	if errors.As(err, &myErr) {
+		switch myErr.Number {
+		case 1792, 1836:
+			return true
+		}
+	}
+	str := strings.ToLower(err.Error())
+	return strings.Contains(str, "read-only") || strings.Contains(str, "read only")
+}
+
+// ErrorClass is the class of the error on the database.
+type ErrorClass string
+
+// The following classes are returned by ClassifyError.
+const (
+	ErrorClassNone       ErrorClass = "none"
+	ErrorClassConnection ErrorClass = "connection"
+	ErrorClassReadOnly   ErrorClass = "read_only"
+	ErrorClassTimeout    ErrorClass = "timeout"
+	ErrorClassCanceled   ErrorClass = "canceled"
+	ErrorClassOverloaded ErrorClass = "overloaded"
+	ErrorClassOther      ErrorClass = "other"
+)
+
+// ClassifyError returns the class of the error.
+func ClassifyError(err error) ErrorClass {
+	var timeoutErr *TimeoutError
+	var overloadedErr *OverloadedError
+	switch {
+	case err == nil:
+		return ErrorClassNone
+	case errors.As(err, &overloadedErr):
+		return ErrorClassOverloaded
+	case errors.As(err, &timeoutErr), errors.Is(err, context.DeadlineExceeded):
+		return ErrorClassTimeout
+	case errors.Is(err, context.Canceled), errors.Is(err, ErrHedgeCanceled):
+		return ErrorClassCanceled
+	case isReadOnlyError(err):
+		return ErrorClassReadOnly
+	case isConnError(err):
+		return ErrorClassConnection
+	}
+	return ErrorClassOther
+}
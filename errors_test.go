@@ -0,0 +1,38 @@
+package sqlw_test
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+
+	"github.com/glassonion1/sqlw"
+)
+
+func TestClassifyError(t *testing.T) {
+	tests := []struct {
+		name string
+		in   error
+		want sqlw.ErrorClass
+	}{
+		{name: "nil", in: nil, want: sqlw.ErrorClassNone},
+		{name: "bad connection", in: fmt.Errorf("failed: %w", driver.ErrBadConn), want: sqlw.ErrorClassConnection},
+		{name: "read only", in: &mysql.MySQLError{Number: 1792, Message: "Cannot execute statement in a READ ONLY transaction."}, want: sqlw.ErrorClassReadOnly},
+		{name: "timeout", in: context.DeadlineExceeded, want: sqlw.ErrorClassTimeout},
+		{name: "canceled", in: context.Canceled, want: sqlw.ErrorClassCanceled},
+		{name: "other", in: errors.New("Error 1146: Table 'app.products' doesn't exist"), want: sqlw.ErrorClassOther},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			if got := sqlw.ClassifyError(tt.in); got != tt.want {
+				t.Errorf("should be %s but got: %s", tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package sqlw
+
+import (
+	"time"
+)
+
+// EventType is the type of the event on the database.
+type EventType string
+
+// The following events are notified to the event handlers.
+const (
+	// EventFailover is notified when the master is switched to another candidate.
+	EventFailover EventType = "failover"
+	// EventFailoverFailed is notified when no candidate is writable.
+	EventFailoverFailed EventType = "failover_failed"
+	// EventNodeEvicted is notified when the health check removes the replica from the selection.
+	EventNodeEvicted EventType = "node_evicted"
+	// EventNodeRestored is notified when the health check returns the replica to the selection.
+	EventNodeRestored EventType = "node_restored"
+	// EventBreakerOpened is notified when the circuit breaker of the node opens.
+	EventBreakerOpened EventType = "breaker_opened"
+	// EventBreakerHalfOpen is notified when the circuit breaker of the node starts probing.
+	EventBreakerHalfOpen EventType = "breaker_half_open"
+	// EventBreakerClosed is notified when the circuit breaker of the node closes.
+	EventBreakerClosed EventType = "breaker_closed"
+	// EventTxCallbackPanicked is notified when the function registered by Tx.AfterCommit or Tx.AfterRollback panics.
+	EventTxCallbackPanicked EventType = "tx_callback_panicked"
+	// EventTxTooOld is notified when WatchTxAge rolls back the transaction older than the max age.
+	EventTxTooOld EventType = "tx_too_old"
+)
+
+// Event is an event on the database.
+type Event struct {
+	Type EventType
+	// Node is the name of the node the event is about.
+	Node string
+	// Previous is the name of the previous master on failover.
+	Previous string
+	Err      error
+	Time     time.Time
+}
+
+// EventHandler handles the events on the database.
+// It is called synchronously, so it should not block.
+type EventHandler func(Event)
+
+// OnEvent registers the event handler.
+//
+// This function should be used outside of Goroutine.
+func (db *DB) OnEvent(h EventHandler) {
+	db.handlers = append(db.handlers, h)
+}
+
+func (db *DB) emit(e Event) {
+	e.Time = time.Now()
+	for _, h := range db.handlers {
+		h(e)
+	}
+}
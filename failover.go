@@ -0,0 +1,177 @@
+package sqlw
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrNoWritableMaster is returned when no master candidate is writable.
+var ErrNoWritableMaster = errors.New("no writable master")
+
+// AddMasterCandidate adds the database that can be promoted to the master on failover.
+// The current master is always a candidate.
+// It is safe for concurrent use.
+func (db *DB) AddMasterCandidate(name string, candidate *sql.DB) error {
+	db.topoMu.Lock()
+	defer db.topoMu.Unlock()
+
+	if db.hasNode(name) {
+		return fmt.Errorf("failed to add %s: %w", name, ErrNodeExists)
+	}
+	for _, opt := range db.poolOpts {
+		opt(candidate)
+	}
+	db.candidates = append(db.candidates, newNode(name, candidate))
+	return nil
+}
+
+// DetectMaster checks the master and the candidates in order, and routes writes to the first one that is writable.
+// The database is writable if it is not read only(@@read_only=0 on MySQL, pg_is_in_recovery()=false on PostgreSQL).
+// EventFailover is notified when the master is switched.
+// The concurrent calls wait for the detection in progress and share its result instead of checking the databases again.
+func (db *DB) DetectMaster(ctx context.Context) error {
+	db.failoverMu.Lock()
+	if c := db.detecting; c != nil {
+		db.failoverMu.Unlock()
+		select {
+		case <-c.done:
+			return c.err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	c := &detectCall{done: make(chan struct{})}
+	db.detecting = c
+	db.failoverMu.Unlock()
+
+	defer func() {
+		db.failoverMu.Lock()
+		db.detecting = nil
+		db.failoverMu.Unlock()
+		close(c.done)
+	}()
+	c.err = db.detectMaster(ctx)
+	return c.err
+}
+
+// detectCall is a call of DetectMaster in progress.
+type detectCall struct {
+	done chan struct{}
+	err  error
+}
+
+func (db *DB) detectMaster(ctx context.Context) error {
+	db.topoMu.RLock()
+	master := db.master
+	nodes := append([]*node{master}, db.candidates...)
+	db.topoMu.RUnlock()
+
+	errList := []error{}
+	for _, n := range nodes {
+		readOnly, err := db.isReadOnly(ctx, n)
+		if err != nil {
+			errList = append(errList, fmt.Errorf("%s: %v", n.name, err))
+			continue
+		}
+		if readOnly {
+			continue
+		}
+		if n != master {
+			db.promote(master, n)
+			db.emit(Event{Type: EventFailover, Node: n.name, Previous: master.name})
+		}
+		return nil
+	}
+
+	err := fmt.Errorf("%w: %v", ErrNoWritableMaster, errList)
+	db.emit(Event{Type: EventFailoverFailed, Node: master.name, Err: err})
+	return err
+}
+
+// WatchMaster detects the master at every interval until the context is done.
+//
+// It blocks until the context is done, so it is usually called in a new goroutine.
+func (db *DB) WatchMaster(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			_ = db.DetectMaster(ctx)
+		}
+	}
+}
+
+func (db *DB) isReadOnly(ctx context.Context, n *node) (bool, error) {
+	n.acquire()
+	defer n.release()
+
+	var readOnly bool
+	if err := n.db.QueryRowContext(ctx, db.dialect.readOnlyQuery()).Scan(&readOnly); err != nil {
+		return false, err
+	}
+	return readOnly, nil
+}
+
+// promote switches the master from the old to the candidate n, and the old master becomes a candidate.
+func (db *DB) promote(old, n *node) {
+	db.topoMu.Lock()
+	defer db.topoMu.Unlock()
+
+	if db.master != old {
+		return
+	}
+	list := []*node{}
+	for _, c := range db.candidates {
+		if c != n {
+			list = append(list, c)
+		}
+	}
+	db.candidates = append(list, old)
+	db.master = n
+}
+
+func (db *DB) hasCandidates() bool {
+	db.topoMu.RLock()
+	defer db.topoMu.RUnlock()
+	return len(db.candidates) > 0
+}
+
+// shouldDetectMaster reports whether the error means that the master may have failed over.
+func (db *DB) shouldDetectMaster(err error) bool {
+	return (isConnError(err) || isReadOnlyError(err)) && db.hasCandidates()
+}
+
+// detectMasterOn detects the master if the error on the node means that the master may have failed over.
+// It reports whether the master is switched from the node.
+func (db *DB) detectMasterOn(ctx context.Context, err error, n *node) bool {
+	if !db.shouldDetectMaster(err) {
+		return false
+	}
+	if derr := db.DetectMaster(ctx); derr != nil {
+		return false
+	}
+	return db.getMaster() != n
+}
+
+// withMaster executes fn on the master.
+// If fn fails because the master failed over, it executes fn again on the new master.
+// fn that is not idempotent is executed again only if the statement is known not to be executed.
+// The context passed to fn carries the number of the attempt.
+func (db *DB) withMaster(ctx context.Context, idempotent bool, fn func(context.Context, *node) error) error {
+	master := db.getMaster()
+	err := fn(ctx, master)
+	if err == nil || !db.detectMasterOn(ctx, err, master) {
+		return err
+	}
+	if !idempotent && !isReadOnlyError(err) && !errors.Is(err, driver.ErrBadConn) {
+		return err
+	}
+	return fn(withAttempt(ctx, 2), db.getMaster())
+}
@@ -0,0 +1,215 @@
+package sqlw_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+
+	"github.com/glassonion1/sqlw"
+	"github.com/glassonion1/sqlw/sqlwtest"
+)
+
+// eventRecorder records the events on the database.
+type eventRecorder struct {
+	mu     sync.Mutex
+	events []sqlw.Event
+}
+
+func (r *eventRecorder) handle(e sqlw.Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, e)
+}
+
+func (r *eventRecorder) types() []sqlw.EventType {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	list := []sqlw.EventType{}
+	for _, e := range r.events {
+		list = append(list, e.Type)
+	}
+	return list
+}
+
+func newFailoverDB(t *testing.T) (*sqlw.DB, *sqlwtest.Cluster, *eventRecorder) {
+	t.Helper()
+	c := sqlwtest.NewCluster(t)
+	db := sqlw.NewDB(c.Open(t, "master"), c.Open(t, "replica0"))
+	if err := db.AddMasterCandidate("standby", c.Open(t, "standby")); err != nil {
+		t.Fatal(err)
+	}
+	c.Update("standby", func(n *sqlwtest.Node) {
+		n.ReadOnly = true
+	})
+	r := &eventRecorder{}
+	db.OnEvent(r.handle)
+	return db, c, r
+}
+
+func TestDBDetectMaster(t *testing.T) {
+	db, c, r := newFailoverDB(t)
+	ctx := context.Background()
+
+	if err := db.DetectMaster(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got := r.types(); len(got) != 0 {
+		t.Errorf("should not fail over: %v", got)
+	}
+
+	// Demotes the master and promotes the standby
+	c.Update("master", func(n *sqlwtest.Node) {
+		n.ReadOnly = true
+	})
+	c.Update("standby", func(n *sqlwtest.Node) {
+		n.ReadOnly = false
+	})
+	if err := db.DetectMaster(ctx); err != nil {
+		t.Fatal(err)
+	}
+	want := []sqlw.Event{{Type: sqlw.EventFailover, Node: "standby", Previous: "master"}}
+	if diff := cmp.Diff(r.events, want, cmpopts.IgnoreFields(sqlw.Event{}, "Time")); diff != "" {
+		t.Errorf("failed to notify failover: %v", diff)
+	}
+
+	if _, err := db.Exec(ctx, "INSERT INTO users(id) VALUES('id_0001')"); err != nil {
+		t.Fatal(err)
+	}
+	got := c.Stmts("standby")
+	if got[len(got)-1] != "INSERT INTO users(id) VALUES('id_0001')" {
+		t.Errorf("should execute on the promoted master: %v", got)
+	}
+
+	// No candidates are writable
+	c.Update("standby", func(n *sqlwtest.Node) {
+		n.ReadOnly = true
+	})
+	if err := db.DetectMaster(ctx); !errors.Is(err, sqlw.ErrNoWritableMaster) {
+		t.Errorf("should be error of %v but got: %v", sqlw.ErrNoWritableMaster, err)
+	}
+	if diff := cmp.Diff(r.types(), []sqlw.EventType{sqlw.EventFailover, sqlw.EventFailoverFailed}); diff != "" {
+		t.Errorf("failed to notify failure: %v", diff)
+	}
+}
+
+func TestDBExecFailover(t *testing.T) {
+	tests := []struct {
+		name   string
+		demote func(*sqlwtest.Node)
+	}{
+		{
+			name: "master becomes read only",
+			demote: func(n *sqlwtest.Node) {
+				n.ReadOnly = true
+			},
+		},
+		{
+			name: "master goes down",
+			demote: func(n *sqlwtest.Node) {
+				n.Down = true
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			db, c, r := newFailoverDB(t)
+			c.Update("master", tt.demote)
+			c.Update("standby", func(n *sqlwtest.Node) {
+				n.ReadOnly = false
+			})
+
+			q := sqlw.SQLMutation("UPDATE users SET name='foo'")
+			if _, err := db.Exec(context.Background(), q); err != nil {
+				t.Fatal(err)
+			}
+			got := c.Stmts("standby")
+			if got[len(got)-1] != q.String() {
+				t.Errorf("should execute again on the new master: %v", got)
+			}
+			if diff := cmp.Diff(r.types(), []sqlw.EventType{sqlw.EventFailover}); diff != "" {
+				t.Errorf("failed to notify failover: %v", diff)
+			}
+		})
+	}
+}
+
+func TestDBTransactionFailover(t *testing.T) {
+	db, c, r := newFailoverDB(t)
+	c.Update("master", func(n *sqlwtest.Node) {
+		n.ReadOnly = true
+	})
+	c.Update("standby", func(n *sqlwtest.Node) {
+		n.ReadOnly = false
+	})
+	ctx := context.Background()
+
+	fn := func(ctx context.Context, tx *sqlw.Tx) error {
+		_, err := tx.Exec(ctx, "DELETE FROM users")
+		return err
+	}
+	if err := db.Transaction(ctx, fn); err == nil {
+		t.Error("should fail on the read only master")
+	}
+	if diff := cmp.Diff(r.types(), []sqlw.EventType{sqlw.EventFailover}); diff != "" {
+		t.Errorf("failed to notify failover: %v", diff)
+	}
+
+	if err := db.Transaction(ctx, fn); err != nil {
+		t.Fatal(err)
+	}
+	got := c.Stmts("standby")
+	if diff := cmp.Diff(got[len(got)-3:], []string{"BEGIN", "DELETE FROM users", "COMMIT"}); diff != "" {
+		t.Errorf("should execute on the new master: %v", diff)
+	}
+}
+
+func TestDBDetectMasterConcurrent(t *testing.T) {
+	db, c, _ := newFailoverDB(t)
+	c.Update("master", func(n *sqlwtest.Node) {
+		n.Latency = 100 * time.Millisecond
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := db.DetectMaster(context.Background()); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := c.Stmts("master"); len(got) != 1 {
+		t.Errorf("concurrent calls should share the detection: %v", got)
+	}
+}
+
+func TestDBExecTimeoutNoFailover(t *testing.T) {
+	db, c, r := newFailoverDB(t)
+	c.Update("master", func(n *sqlwtest.Node) {
+		n.Latency = 100 * time.Millisecond
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := db.Exec(ctx, "DELETE FROM users"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("should be error of %v but got: %v", context.DeadlineExceeded, err)
+	}
+	if got := r.types(); len(got) != 0 {
+		t.Errorf("timeout of the caller should not detect the master: %v", got)
+	}
+	if got := c.Stmts("standby"); len(got) != 0 {
+		t.Errorf("should not check the candidates: %v", got)
+	}
+}
@@ -5,4 +5,5 @@ go 1.15
 require (
 	github.com/go-sql-driver/mysql v1.5.0
 	github.com/google/go-cmp v0.5.5
+	gopkg.in/yaml.v2 v2.4.0
 )
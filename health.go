@@ -0,0 +1,316 @@
+package sqlw
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The following errors are returned by the health checks.
+var (
+	ErrReadOnly     = errors.New("database is read only")
+	ErrReplicaLag   = errors.New("replication lag exceeds the limit")
+	ErrNotReplicate = errors.New("replication is not running")
+)
+
+// Role is the role of the node in the database.
+type Role string
+
+// The following roles are supported.
+const (
+	RoleMaster    Role = "master"
+	RoleReplica   Role = "replica"
+	RoleCandidate Role = "candidate"
+)
+
+// NodeHealth is the health of a node.
+type NodeHealth struct {
+	Name     string        `json:"name"`
+	Role     Role          `json:"role"`
+	Healthy  bool          `json:"healthy"`
+	ReadOnly bool          `json:"read_only"`
+	Latency  time.Duration `json:"latency"`
+	// Lag is the replication lag, it is checked on the replicas only.
+	// If the lag can not be selected, for example without the REPLICATION CLIENT privilege on MySQL,
+	// Error is set but the replica is still healthy unless SetMaxReplicaLag is set.
+	Lag   time.Duration `json:"lag"`
+	Error string        `json:"error,omitempty"`
+}
+
+// HealthReport is the health of all nodes in the database.
+type HealthReport struct {
+	Nodes []NodeHealth `json:"nodes"`
+	Time  time.Time    `json:"time"`
+}
+
+// Master returns the health of the master.
+func (r HealthReport) Master() NodeHealth {
+	for _, n := range r.Nodes {
+		if n.Role == RoleMaster {
+			return n
+		}
+	}
+	return NodeHealth{Role: RoleMaster}
+}
+
+// Replicas returns the health of the replicas.
+func (r HealthReport) Replicas() []NodeHealth {
+	list := []NodeHealth{}
+	for _, n := range r.Nodes {
+		if n.Role == RoleReplica {
+			list = append(list, n)
+		}
+	}
+	return list
+}
+
+// ReadQuorum decides whether the database is readable from the health report.
+type ReadQuorum func(HealthReport) error
+
+// QuorumAll requires the master and all replicas to be healthy. It is the default.
+func QuorumAll(r HealthReport) error {
+	errList := []string{}
+	for _, n := range r.Nodes {
+		if n.Role != RoleCandidate && !n.Healthy {
+			errList = append(errList, fmt.Sprintf("failed to ping %s: %s", n.Name, n.Error))
+		}
+	}
+	if len(errList) > 0 {
+		str := strings.Join(errList, ",")
+		return errors.New(str)
+	}
+	return nil
+}
+
+// QuorumAny requires the master or at least one replica to be healthy.
+func QuorumAny(r HealthReport) error {
+	errList := []string{}
+	for _, n := range r.Nodes {
+		if n.Role == RoleCandidate {
+			continue
+		}
+		if n.Healthy {
+			return nil
+		}
+		errList = append(errList, fmt.Sprintf("failed to ping %s: %s", n.Name, n.Error))
+	}
+	str := strings.Join(errList, ",")
+	return errors.New(str)
+}
+
+// QuorumReplicas requires at least n replicas to be healthy.
+func QuorumReplicas(n int) ReadQuorum {
+	return func(r HealthReport) error {
+		count := 0
+		for _, h := range r.Replicas() {
+			if h.Healthy {
+				count++
+			}
+		}
+		if count < n {
+			return fmt.Errorf("%d of %d required replicas are healthy", count, n)
+		}
+		return nil
+	}
+}
+
+// SetReadQuorum sets the rule that Readable uses.
+func (db *DB) SetReadQuorum(q ReadQuorum) {
+	db.topoMu.Lock()
+	defer db.topoMu.Unlock()
+	db.quorum = q
+}
+
+// SetMaxReplicaLag sets the replication lag above which the replica is unhealthy.
+// Zero means no limit.
+// With the limit, the replica whose lag can not be selected is also unhealthy,
+// so the user needs the REPLICATION CLIENT privilege on MySQL.
+func (db *DB) SetMaxReplicaLag(d time.Duration) {
+	db.topoMu.Lock()
+	defer db.topoMu.Unlock()
+	db.maxLag = d
+}
+
+// Health checks all nodes concurrently and reports their health.
+// The master and the candidates are checked whether they are read only, and the replicas are checked for the replication lag.
+func (db *DB) Health(ctx context.Context) HealthReport {
+	db.topoMu.RLock()
+	type target struct {
+		n    *node
+		role Role
+	}
+	targets := []target{{db.master, RoleMaster}}
+	for _, r := range db.readreplicas {
+		targets = append(targets, target{r, RoleReplica})
+	}
+	for _, c := range db.candidates {
+		targets = append(targets, target{c, RoleCandidate})
+	}
+	maxLag := db.maxLag
+	db.topoMu.RUnlock()
+
+	nodes := make([]NodeHealth, len(targets))
+	var wg sync.WaitGroup
+	for i, t := range targets {
+		wg.Add(1)
+		go func(i int, n *node, role Role) {
+			defer wg.Done()
+			nodes[i] = db.checkNode(ctx, n, role, maxLag)
+		}(i, t.n, t.role)
+	}
+	wg.Wait()
+
+	return HealthReport{
+		Nodes: nodes,
+		Time:  time.Now(),
+	}
+}
+
+func (db *DB) checkNode(ctx context.Context, n *node, role Role, maxLag time.Duration) NodeHealth {
+	h := NodeHealth{
+		Name: n.name,
+		Role: role,
+	}
+
+	start := time.Now()
+	err := n.db.PingContext(ctx)
+	h.Latency = time.Since(start)
+	if err != nil {
+		h.Error = err.Error()
+		return h
+	}
+
+	if role == RoleReplica {
+		lag, err := db.replicaLag(ctx, n)
+		h.Lag = lag
+		if err == nil && maxLag > 0 && lag > maxLag {
+			err = fmt.Errorf("%w: %v", ErrReplicaLag, lag)
+		}
+		if err != nil {
+			h.Error = err.Error()
+			if maxLag > 0 {
+				return h
+			}
+		}
+	} else {
+		readOnly, err := db.isReadOnly(ctx, n)
+		if err != nil {
+			h.Error = err.Error()
+			return h
+		}
+		h.ReadOnly = readOnly
+	}
+
+	h.Healthy = true
+	return h
+}
+
+// replicaLag selects the replication lag of the replica.
+// The lag is zero if the node is not a replica.
+func (db *DB) replicaLag(ctx context.Context, n *node) (time.Duration, error) {
+	n.acquire()
+	defer n.release()
+
+	var rows *sql.Rows
+	var err error
+	for _, q := range db.dialect.lagQueries() {
+		if rows, err = n.db.QueryContext(ctx, q); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	cols, err := rows.Columns()
+	if err != nil {
+		return 0, err
+	}
+	if !rows.Next() {
+		return 0, rows.Err()
+	}
+	values := make([]sql.RawBytes, len(cols))
+	dest := make([]interface{}, len(cols))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	if err := rows.Scan(dest...); err != nil {
+		return 0, err
+	}
+
+	for i, col := range cols {
+		switch col {
+		case "Seconds_Behind_Master", "Seconds_Behind_Source", "lag":
+		default:
+			continue
+		}
+		if values[i] == nil {
+			return 0, ErrNotReplicate
+		}
+		sec, err := strconv.ParseFloat(string(values[i]), 64)
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(sec * float64(time.Second)), nil
+	}
+	return 0, nil
+}
+
+// WatchHealth checks the health at every interval until the context is done.
+// The unhealthy replicas are removed from the selection until they become healthy,
+// and the master is detected again if it is not writable and there are candidates.
+//
+// It blocks until the context is done, so it is usually called in a new goroutine.
+func (db *DB) WatchHealth(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		db.applyHealth(ctx, db.Health(ctx))
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func (db *DB) applyHealth(ctx context.Context, r HealthReport) {
+	_, replicas := db.nodes()
+	byName := map[string]*node{}
+	for _, n := range replicas {
+		byName[n.name] = n
+	}
+
+	breakerOn := db.getBreakerPolicy() != nil
+	for _, h := range r.Replicas() {
+		n, ok := byName[h.Name]
+		if !ok {
+			continue
+		}
+		if breakerOn && !h.Healthy && n.breaker.trip(time.Now()) {
+			db.emitBreaker(h.Name, BreakerOpen, errors.New(h.Error))
+		}
+		if breakerOn && h.Healthy && n.breaker.probe() {
+			db.emitBreaker(h.Name, BreakerHalfOpen, nil)
+		}
+		if !n.setHealthy(h.Healthy) {
+			continue
+		}
+		if h.Healthy {
+			db.emit(Event{Type: EventNodeRestored, Node: h.Name})
+		} else {
+			db.emit(Event{Type: EventNodeEvicted, Node: h.Name, Err: errors.New(h.Error)})
+		}
+	}
+
+	m := r.Master()
+	if (!m.Healthy || m.ReadOnly) && db.hasCandidates() {
+		_ = db.DetectMaster(ctx)
+	}
+}
@@ -0,0 +1,224 @@
+package sqlw_test
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+
+	"github.com/glassonion1/sqlw"
+	"github.com/glassonion1/sqlw/sqlwtest"
+)
+
+func TestDBWritableRole(t *testing.T) {
+	c := sqlwtest.NewCluster(t)
+	db := sqlw.NewDB(c.Open(t, "master"))
+
+	if err := db.Writable(); err != nil {
+		t.Errorf("master should be writable: %v", err)
+	}
+
+	c.Update("master", func(n *sqlwtest.Node) {
+		n.ReadOnly = true
+	})
+	if err := db.Writable(); !errors.Is(err, sqlw.ErrReadOnly) {
+		t.Errorf("should be error of %v but got: %v", sqlw.ErrReadOnly, err)
+	}
+}
+
+func TestDBHealth(t *testing.T) {
+	c := sqlwtest.NewCluster(t)
+	db := sqlw.NewDB(c.Open(t, "master"), c.Open(t, "replica0"), c.Open(t, "replica1"), c.Open(t, "replica2"))
+	if err := db.AddMasterCandidate("standby", c.Open(t, "standby")); err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxReplicaLag(10 * time.Second)
+
+	c.Update("replica0", func(n *sqlwtest.Node) {
+		n.Lag = 3 * time.Second
+	})
+	c.Update("replica1", func(n *sqlwtest.Node) {
+		n.Down = true
+	})
+	c.Update("replica2", func(n *sqlwtest.Node) {
+		n.Lag = 30 * time.Second
+	})
+	c.Update("standby", func(n *sqlwtest.Node) {
+		n.ReadOnly = true
+	})
+
+	got := db.Health(context.Background())
+	want := sqlw.HealthReport{
+		Nodes: []sqlw.NodeHealth{
+			{Name: "master", Role: sqlw.RoleMaster, Healthy: true},
+			{Name: "replica0", Role: sqlw.RoleReplica, Healthy: true, Lag: 3 * time.Second},
+			{Name: "replica1", Role: sqlw.RoleReplica, Error: "driver: bad connection"},
+			{Name: "replica2", Role: sqlw.RoleReplica, Lag: 30 * time.Second, Error: "replication lag exceeds the limit: 30s"},
+			{Name: "standby", Role: sqlw.RoleCandidate, Healthy: true, ReadOnly: true},
+		},
+	}
+	opt := cmpopts.IgnoreFields(sqlw.NodeHealth{}, "Latency")
+	if diff := cmp.Diff(got, want, opt, cmpopts.IgnoreFields(sqlw.HealthReport{}, "Time")); diff != "" {
+		t.Errorf("failed to report health: %v", diff)
+	}
+}
+
+func TestDBReadableQuorum(t *testing.T) {
+	tests := []struct {
+		name    string
+		quorum  sqlw.ReadQuorum
+		down    []string
+		wantErr bool
+	}{
+		{
+			name:    "all nodes are healthy",
+			quorum:  sqlw.QuorumAll,
+			wantErr: false,
+		},
+		{
+			name:    "a replica is down",
+			quorum:  sqlw.QuorumAll,
+			down:    []string{"replica0"},
+			wantErr: true,
+		},
+		{
+			name:    "a replica is down with any",
+			quorum:  sqlw.QuorumAny,
+			down:    []string{"replica0"},
+			wantErr: false,
+		},
+		{
+			name:    "master is down with any",
+			quorum:  sqlw.QuorumAny,
+			down:    []string{"master", "replica0"},
+			wantErr: false,
+		},
+		{
+			name:    "all nodes are down with any",
+			quorum:  sqlw.QuorumAny,
+			down:    []string{"master", "replica0", "replica1"},
+			wantErr: true,
+		},
+		{
+			name:    "two replicas are required",
+			quorum:  sqlw.QuorumReplicas(2),
+			down:    []string{"replica1"},
+			wantErr: true,
+		},
+		{
+			name:    "one replica is required",
+			quorum:  sqlw.QuorumReplicas(1),
+			down:    []string{"master", "replica1"},
+			wantErr: false,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			c := sqlwtest.NewCluster(t)
+			db := sqlw.NewDB(c.Open(t, "master"), c.Open(t, "replica0"), c.Open(t, "replica1"))
+			db.SetReadQuorum(tt.quorum)
+			for _, name := range tt.down {
+				c.Update(name, func(n *sqlwtest.Node) {
+					n.Down = true
+				})
+			}
+
+			err := db.Readable()
+			if tt.wantErr != (err != nil) {
+				t.Errorf("wantErr: %v, err: %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestDBWatchHealth(t *testing.T) {
+	c := sqlwtest.NewCluster(t)
+	db := sqlw.NewDB(c.Open(t, "master"), c.Open(t, "replica0"), c.Open(t, "replica1"))
+	r := &eventRecorder{}
+	db.OnEvent(r.handle)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go db.WatchHealth(ctx, 10*time.Millisecond)
+
+	waitFor := func(want []sqlw.EventType) {
+		t.Helper()
+		deadline := time.Now().Add(time.Second)
+		for !cmp.Equal(r.types(), want) {
+			if time.Now().After(deadline) {
+				t.Fatalf("failed to check health: %v", cmp.Diff(r.types(), want))
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+
+	c.Update("replica0", func(n *sqlwtest.Node) {
+		n.Down = true
+	})
+	waitFor([]sqlw.EventType{sqlw.EventNodeEvicted})
+	if diff := cmp.Diff(queriedNodes(t, db, 50), map[string]bool{"replica1": true}); diff != "" {
+		t.Errorf("should not query the evicted replica: %v", diff)
+	}
+
+	c.Update("replica0", func(n *sqlwtest.Node) {
+		n.Down = false
+	})
+	waitFor([]sqlw.EventType{sqlw.EventNodeEvicted, sqlw.EventNodeRestored})
+	want := map[string]bool{"replica0": true, "replica1": true}
+	if diff := cmp.Diff(queriedNodes(t, db, 100), want); diff != "" {
+		t.Errorf("should query the restored replica: %v", diff)
+	}
+}
+
+func TestDBHealthLagError(t *testing.T) {
+	c := sqlwtest.NewCluster(t)
+	db := sqlw.NewDB(c.Open(t, "master"), c.Open(t, "replica0"), c.Open(t, "replica1"))
+	denied := errors.New("Error 1227: Access denied; you need (at least one of) the SUPER, REPLICATION CLIENT privilege(s) for this operation")
+	c.Update("replica0", func(n *sqlwtest.Node) {
+		n.Errs["SHOW SLAVE STATUS"] = denied
+		n.Errs["SHOW REPLICA STATUS"] = denied
+	})
+	// MySQL 8.4 does not have SHOW SLAVE STATUS.
+	c.Update("replica1", func(n *sqlwtest.Node) {
+		n.Errs["SHOW SLAVE STATUS"] = errors.New("Error 1064: You have an error in your SQL syntax")
+		n.Results["SHOW REPLICA STATUS"] = sqlwtest.Result{
+			Columns: []string{"Seconds_Behind_Source"},
+			Rows:    [][]driver.Value{{int64(30)}},
+		}
+	})
+	ctx := context.Background()
+
+	if err := db.Readable(); err != nil {
+		t.Errorf("should be readable without the lag limit: %v", err)
+	}
+	got := db.Health(ctx)
+	want := []sqlw.NodeHealth{
+		{Name: "replica0", Role: sqlw.RoleReplica, Healthy: true, Error: denied.Error()},
+		{Name: "replica1", Role: sqlw.RoleReplica, Healthy: true, Lag: 30 * time.Second},
+	}
+	opt := cmpopts.IgnoreFields(sqlw.NodeHealth{}, "Latency")
+	if diff := cmp.Diff(got.Replicas(), want, opt); diff != "" {
+		t.Errorf("failed to report health: %v", diff)
+	}
+
+	db.SetMaxReplicaLag(10 * time.Second)
+	got = db.Health(ctx)
+	want = []sqlw.NodeHealth{
+		{Name: "replica0", Role: sqlw.RoleReplica, Error: denied.Error()},
+		{Name: "replica1", Role: sqlw.RoleReplica, Lag: 30 * time.Second, Error: "replication lag exceeds the limit: 30s"},
+	}
+	if diff := cmp.Diff(got.Replicas(), want, opt); diff != "" {
+		t.Errorf("failed to report health: %v", diff)
+	}
+	if err := db.Readable(); err == nil {
+		t.Error("should not be readable with the lag limit")
+	}
+}
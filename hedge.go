@@ -0,0 +1,235 @@
+package sqlw
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"runtime"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrHedgeCanceled is the error of the hedged read canceled because the other read succeeded first.
+// It is passed to the hooks only, the caller receives the result of the other read.
+var ErrHedgeCanceled = errors.New("hedged read canceled by the other read")
+
+// hedgeSamples is the number of the recent read latencies the hedge delay is computed from.
+const hedgeSamples = 1000
+
+// hedgeRecompute is the number of the samples after which the hedge delay is computed again.
+const hedgeRecompute = 50
+
+// hedgeMaxTokens is the max number of the hedged reads that can be issued in a burst.
+const hedgeMaxTokens = 10
+
+// HedgePolicy is the policy of the hedged reads.
+type HedgePolicy struct {
+	// Percentile is the percentile of the recent read latencies used as the delay before hedging, such as 0.95.
+	Percentile float64
+	// MinDelay is the lower limit of the delay.
+	MinDelay time.Duration
+	// Budget is the max ratio of the hedged reads to the reads with the context by WithHedging, such as 0.05.
+	Budget float64
+}
+
+type hedgeKey struct{}
+
+// WithHedging returns a new context that enables the hedged reads for the queries with the context.
+// The reads are hedged only if the policy is set by SetHedgePolicy.
+func WithHedging(ctx context.Context) context.Context {
+	return context.WithValue(ctx, hedgeKey{}, true)
+}
+
+// hedger keeps the recent read latencies and the budget of the hedged reads.
+type hedger struct {
+	mu      sync.Mutex
+	policy  *HedgePolicy
+	samples []time.Duration
+	next    int
+	stale   int
+	delay   time.Duration
+	tokens  float64
+}
+
+// SetHedgePolicy sets the policy of the hedged reads, nil disables the hedged reads.
+//
+// If a query with the context by WithHedging does not respond within the delay,
+// the same query is executed on another replica and the first successful result is used.
+// The context of the other query is canceled. Only the SELECT statements that do not lock the rows are hedged.
+//
+// QueryInfo.Hedged of the query on another replica is true, and the canceled query is passed to the hooks with ErrHedgeCanceled.
+func (db *DB) SetHedgePolicy(p *HedgePolicy) {
+	db.hedge.mu.Lock()
+	defer db.hedge.mu.Unlock()
+	db.hedge.policy = p
+	db.hedge.tokens = 0
+}
+
+// hedgeDelay returns the delay before hedging the query, and whether the query can be hedged.
+func (h *hedger) hedgeDelay(ctx context.Context, query string) (time.Duration, bool) {
+	if on, _ := ctx.Value(hedgeKey{}).(bool); !on {
+		return 0, false
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.policy == nil || !isReadOnlyStatement(query) {
+		return 0, false
+	}
+	h.tokens += h.policy.Budget
+	if h.tokens > hedgeMaxTokens {
+		h.tokens = hedgeMaxTokens
+	}
+	if h.delay < h.policy.MinDelay {
+		return h.policy.MinDelay, true
+	}
+	return h.delay, true
+}
+
+// take takes a token of the budget, and reports whether the budget is left.
+func (h *hedger) take() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.tokens < 1 {
+		return false
+	}
+	h.tokens--
+	return true
+}
+
+// observe records the latency of the successful read.
+func (h *hedger) observe(d time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.policy == nil {
+		return
+	}
+	if len(h.samples) < hedgeSamples {
+		h.samples = append(h.samples, d)
+	} else {
+		h.samples[h.next] = d
+		h.next = (h.next + 1) % hedgeSamples
+	}
+	h.stale++
+	if h.stale < hedgeRecompute && h.delay > 0 {
+		return
+	}
+	h.stale = 0
+	sorted := append([]time.Duration{}, h.samples...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i] < sorted[j]
+	})
+	i := int(float64(len(sorted)) * h.policy.Percentile)
+	if i >= len(sorted) {
+		i = len(sorted) - 1
+	}
+	h.delay = sorted[i]
+}
+
+// hedgeResult is the result of a read.
+type hedgeResult struct {
+	index int
+	value interface{}
+	err   error
+}
+
+type hedgeReadKey struct{}
+
+// hedgeRead is one of the reads by withHedge.
+type hedgeRead struct {
+	// hedged is true if the read is issued on another replica after the delay.
+	hedged bool
+	// lost is set to 1 before the read is canceled because the other read succeeded.
+	lost   int32
+	cancel context.CancelFunc
+}
+
+func hedgeReadFrom(ctx context.Context) *hedgeRead {
+	r, _ := ctx.Value(hedgeReadKey{}).(*hedgeRead)
+	return r
+}
+
+// isHedged reports whether the read is the hedged read.
+func (r *hedgeRead) isHedged() bool {
+	return r != nil && r.hedged
+}
+
+// isLost reports whether the read is canceled because the other read succeeded.
+func (r *hedgeRead) isLost() bool {
+	return r != nil && atomic.LoadInt32(&r.lost) == 1
+}
+
+// lose cancels the read because the other read succeeded.
+func (r *hedgeRead) lose() {
+	atomic.StoreInt32(&r.lost, 1)
+	r.cancel()
+}
+
+// release cancels the context of the read once the result is no longer used.
+// The rows are read after the call returns, so the context of the successful read is canceled
+// after the rows are closed and released by the caller.
+func (r *hedgeRead) release(res hedgeResult) {
+	if res.err == nil {
+		switch v := res.value.(type) {
+		case *sql.Rows:
+			runtime.SetFinalizer(v, func(*sql.Rows) { r.cancel() })
+			return
+		case *sql.Row:
+			runtime.SetFinalizer(v, func(*sql.Row) { r.cancel() })
+			return
+		}
+	}
+	r.cancel()
+}
+
+// withHedge executes fn on the replica, and executes it on another replica if it does not respond within the delay.
+// It returns the first successful result, or the first error if both fail.
+func (db *DB) withHedge(ctx context.Context, n *node, delay time.Duration, fn func(context.Context, *node) (interface{}, error)) (interface{}, error) {
+	ch := make(chan hedgeResult, 2)
+	reads := []*hedgeRead{}
+	start := func(n *node, hedged bool) {
+		read := &hedgeRead{hedged: hedged}
+		ctx, cancel := context.WithCancel(context.WithValue(ctx, hedgeReadKey{}, read))
+		read.cancel = cancel
+		reads = append(reads, read)
+		index := len(reads) - 1
+		go func() {
+			begin := time.Now()
+			v, err := fn(ctx, n)
+			if err == nil {
+				db.hedge.observe(time.Since(begin))
+			}
+			ch <- hedgeResult{index: index, value: v, err: err}
+		}()
+	}
+	start(n, false)
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case r := <-ch:
+		reads[0].release(r)
+		return r.value, r.err
+	case <-timer.C:
+	}
+
+	second := db.getReplicaExcept([]*node{n})
+	if second == nil || second == db.getMaster() || !db.hedge.take() {
+		r := <-ch
+		reads[0].release(r)
+		return r.value, r.err
+	}
+	start(second, true)
+
+	r := <-ch
+	if r.err != nil {
+		if other := <-ch; other.err == nil {
+			r = other
+		}
+	}
+	// Cancels the loser, the context of the winner is kept for reading the rows.
+	reads[1-r.index].lose()
+	reads[r.index].release(r)
+	return r.value, r.err
+}
@@ -0,0 +1,222 @@
+package sqlw_test
+
+import (
+	"context"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/glassonion1/sqlw"
+	"github.com/glassonion1/sqlw/sqlwtest"
+)
+
+// newHedgeDB returns the database whose slow replica0 is selected first.
+func newHedgeDB(t *testing.T) (*sqlw.DB, *hookRecorder) {
+	c := sqlwtest.NewCluster(t)
+	db := sqlw.NewDB(c.Open(t, "master"), c.Open(t, "replica0"), c.Open(t, "replica1"))
+	c.Update("replica0", func(n *sqlwtest.Node) {
+		n.Latency = 200 * time.Millisecond
+	})
+	if err := db.SetDrained("replica1", true); err != nil {
+		t.Fatal(err)
+	}
+	var once sync.Once
+	r := &hookRecorder{}
+	db.Use(sqlw.HookFuncs{
+		BeforeFunc: func(ctx context.Context, info *sqlw.QueryInfo) (context.Context, error) {
+			// Makes replica1 be selected for hedging
+			once.Do(func() {
+				_ = db.SetDrained("replica1", false)
+			})
+			return ctx, nil
+		},
+	}, r)
+	return db, r
+}
+
+func TestDBSetHedgePolicy(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy *sqlw.HedgePolicy
+		ctx    context.Context
+		query  sqlw.SQLQuery
+		want   string
+	}{
+		{
+			name:   "hedged",
+			policy: &sqlw.HedgePolicy{Percentile: 0.9, MinDelay: 10 * time.Millisecond, Budget: 1},
+			ctx:    sqlw.WithHedging(context.Background()),
+			query:  "SELECT * FROM users",
+			want:   "replica1",
+		},
+		{
+			name:   "not opted in",
+			policy: &sqlw.HedgePolicy{Percentile: 0.9, MinDelay: 10 * time.Millisecond, Budget: 1},
+			ctx:    context.Background(),
+			query:  "SELECT * FROM users",
+			want:   "replica0",
+		},
+		{
+			name:   "locking read",
+			policy: &sqlw.HedgePolicy{Percentile: 0.9, MinDelay: 10 * time.Millisecond, Budget: 1},
+			ctx:    sqlw.WithHedging(context.Background()),
+			query:  "SELECT * FROM users FOR UPDATE",
+			want:   "replica0",
+		},
+		{
+			name:   "out of budget",
+			policy: &sqlw.HedgePolicy{Percentile: 0.9, MinDelay: 10 * time.Millisecond, Budget: 0.5},
+			ctx:    sqlw.WithHedging(context.Background()),
+			query:  "SELECT * FROM users",
+			want:   "replica0",
+		},
+		{
+			name:  "no policy",
+			ctx:   sqlw.WithHedging(context.Background()),
+			query: "SELECT * FROM users",
+			want:  "replica0",
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			db, r := newHedgeDB(t)
+			db.SetHedgePolicy(tt.policy)
+
+			var name string
+			if err := db.QueryRow(tt.ctx, tt.query).Scan(&name); err != nil {
+				t.Fatal(err)
+			}
+			if name != tt.want {
+				t.Errorf("should be read from %s but got: %s", tt.want, name)
+			}
+			if tt.want != "replica1" {
+				return
+			}
+
+			// Waits for the canceled query
+			for i := 0; i < 100 && len(r.summary()) < 2; i++ {
+				time.Sleep(10 * time.Millisecond)
+			}
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			if len(r.infos) != 2 || r.infos[1].Node != "replica0" || r.infos[1].Err != sqlw.ErrHedgeCanceled || r.infos[1].Hedged {
+				t.Errorf("the slow query should be canceled: %v", r.infos)
+			}
+			if !r.infos[0].Hedged || r.infos[0].Attempt != 1 {
+				t.Errorf("the hedged query should not be a retry: %+v", r.infos[0])
+			}
+		})
+	}
+}
+
+func TestDBSetHedgePolicyRelease(t *testing.T) {
+	db, _ := newHedgeDB(t)
+	db.SetHedgePolicy(&sqlw.HedgePolicy{Percentile: 0.9, MinDelay: 10 * time.Millisecond, Budget: 1})
+	var mu sync.Mutex
+	ctxs := map[string]context.Context{}
+	db.Use(sqlw.HookFuncs{
+		BeforeFunc: func(ctx context.Context, info *sqlw.QueryInfo) (context.Context, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			ctxs[info.Node] = ctx
+			return ctx, nil
+		},
+	})
+	ctx, cancel := context.WithCancel(sqlw.WithHedging(context.Background()))
+	defer cancel()
+
+	rows, err := db.Query(ctx, "SELECT * FROM users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	winner := ctxs["replica1"]
+	mu.Unlock()
+	if winner == nil || winner.Err() != nil {
+		t.Fatalf("the context of the winner should be alive while reading the rows: %v", winner)
+	}
+	for rows.Next() {
+	}
+	if err := rows.Close(); err != nil {
+		t.Fatal(err)
+	}
+	rows = nil
+
+	// The context of the winner is canceled after the rows are released.
+	for i := 0; i < 100 && winner.Err() == nil; i++ {
+		runtime.GC()
+		time.Sleep(10 * time.Millisecond)
+	}
+	if winner.Err() == nil {
+		t.Error("the context of the winner should be canceled after the rows are closed")
+	}
+	if ctx.Err() != nil {
+		t.Errorf("the context of the caller should not be canceled: %v", ctx.Err())
+	}
+}
+
+func TestDBSetHedgePolicyBreaker(t *testing.T) {
+	c := sqlwtest.NewCluster(t)
+	db := sqlw.NewDB(c.Open(t, "master"), c.Open(t, "replica0"), c.Open(t, "replica1"))
+	db.SetBreakerPolicy(&sqlw.BreakerPolicy{
+		Window:           time.Minute,
+		MinRequests:      1,
+		ErrorRate:        0.5,
+		OpenTimeout:      30 * time.Millisecond,
+		HalfOpenRequests: 1,
+	})
+	c.Update("replica1", func(n *sqlwtest.Node) {
+		n.Down = true
+	})
+	for i := 0; i < 50 && breakerOf(db, "replica1") != sqlw.BreakerOpen; i++ {
+		var name string
+		_ = db.QueryRow(context.Background(), "SELECT node").Scan(&name)
+	}
+	if got := breakerOf(db, "replica1"); got != sqlw.BreakerOpen {
+		t.Fatalf("breaker should be open but got: %s", got)
+	}
+	c.Update("replica1", func(n *sqlwtest.Node) {
+		n.Down = false
+	})
+	c.Update("replica0", func(n *sqlwtest.Node) {
+		n.Latency = 50 * time.Millisecond
+	})
+	if err := db.SetDrained("replica1", true); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(40 * time.Millisecond)
+
+	// replica1 is selected for hedging as a probe, but the hedged query is not issued without the budget.
+	var once sync.Once
+	db.Use(sqlw.HookFuncs{
+		BeforeFunc: func(ctx context.Context, info *sqlw.QueryInfo) (context.Context, error) {
+			once.Do(func() {
+				_ = db.SetDrained("replica1", false)
+			})
+			return ctx, nil
+		},
+	})
+	db.SetHedgePolicy(&sqlw.HedgePolicy{Percentile: 0.9, MinDelay: 10 * time.Millisecond, Budget: 0})
+	var name string
+	if err := db.QueryRow(sqlw.WithHedging(context.Background()), "SELECT * FROM users").Scan(&name); err != nil {
+		t.Fatal(err)
+	}
+	if name != "replica0" {
+		t.Errorf("should not be hedged but got: %s", name)
+	}
+
+	c.Update("replica0", func(n *sqlwtest.Node) {
+		n.Latency = 0
+	})
+	if got := queriedNodes(t, db, 50); !got["replica1"] {
+		t.Errorf("half-open replica should be probed: %v", got)
+	}
+	if got := breakerOf(db, "replica1"); got != sqlw.BreakerClosed {
+		t.Errorf("breaker should be closed but got: %s", got)
+	}
+}
@@ -0,0 +1,201 @@
+package sqlw
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Op is the kind of the call on the database.
+type Op string
+
+// The following calls are passed to the hooks.
+const (
+	OpQuery    Op = "query"
+	OpExec     Op = "exec"
+	OpPrepare  Op = "prepare"
+	OpBegin    Op = "begin"
+	OpCommit   Op = "commit"
+	OpRollback Op = "rollback"
+	// OpTransaction wraps the whole of DB.Transaction and DB.TransactionTx.
+	OpTransaction Op = "transaction"
+)
+
+// QueryInfo describes a call on the database.
+type QueryInfo struct {
+	Op    Op
+	Query string
+	Args  []interface{}
+	// Node is the name of the node the call is executed on.
+	Node string
+	Role Role
+	// InTx is true if the call is executed in a transaction.
+	InTx bool
+	// Caller is the caller set by WithCaller.
+	Caller string
+	// Attempt is the number of the attempt of the call, it is more than 1 if the call is retried.
+	Attempt int
+	// Hedged is true if the call is the hedged read issued on another replica, which is not a retry.
+	Hedged bool
+	// Dialect is the dialect of the database.
+	Dialect Dialect
+	// The following fields are set after the call.
+	Start    time.Time
+	Duration time.Duration
+	// RowsAffected is the number of rows affected by OpExec, -1 if unknown.
+	RowsAffected int64
+	Err          error
+}
+
+// Hook intercepts the calls on the database.
+type Hook interface {
+	// Before is called before the call.
+	// The returned context is passed to the following hooks and the call.
+	// If it returns an error, the call is not executed and the error is returned to the caller.
+	Before(ctx context.Context, info *QueryInfo) (context.Context, error)
+	// After is called after the call.
+	// It is called only if Before of the same hook is called and returned no error.
+	After(ctx context.Context, info *QueryInfo)
+}
+
+// HookFuncs is an adapter to use the functions as a Hook.
+// The nil functions are skipped.
+type HookFuncs struct {
+	BeforeFunc func(ctx context.Context, info *QueryInfo) (context.Context, error)
+	AfterFunc  func(ctx context.Context, info *QueryInfo)
+}
+
+// Before calls h.BeforeFunc.
+func (h HookFuncs) Before(ctx context.Context, info *QueryInfo) (context.Context, error) {
+	if h.BeforeFunc == nil {
+		return ctx, nil
+	}
+	return h.BeforeFunc(ctx, info)
+}
+
+// After calls h.AfterFunc.
+func (h HookFuncs) After(ctx context.Context, info *QueryInfo) {
+	if h.AfterFunc != nil {
+		h.AfterFunc(ctx, info)
+	}
+}
+
+// Use registers the hooks. Before of the hooks are called in order, and After are called in reverse order.
+//
+// This function should be used outside of Goroutine.
+func (db *DB) Use(hooks ...Hook) {
+	db.hooks = append(db.hooks, hooks...)
+}
+
+// roleOf returns the role of the node.
+func (db *DB) roleOf(n *node) Role {
+	db.topoMu.RLock()
+	defer db.topoMu.RUnlock()
+	switch {
+	case n == db.master:
+		return RoleMaster
+	case containsNode(db.candidates, n):
+		return RoleCandidate
+	}
+	return RoleReplica
+}
+
+func containsNode(list []*node, n *node) bool {
+	for _, l := range list {
+		if l == n {
+			return true
+		}
+	}
+	return false
+}
+
+// errAborted is passed to the hooks as the error of the call that did not return by a panic or runtime.Goexit.
+var errAborted = errors.New("call aborted by panic or runtime.Goexit")
+
+type attemptKey struct{}
+
+// withAttempt returns a new context with the number of the attempt of the call.
+func withAttempt(ctx context.Context, attempt int) context.Context {
+	return context.WithValue(ctx, attemptKey{}, attempt)
+}
+
+func attemptFrom(ctx context.Context) int {
+	if attempt, ok := ctx.Value(attemptKey{}).(int); ok {
+		return attempt
+	}
+	return 1
+}
+
+// run executes fn on the node through the hooks.
+func (db *DB) run(ctx context.Context, info *QueryInfo, n *node, fn func(context.Context) error) error {
+	ctx, timeout, cancel := db.withTimeout(ctx, info)
+	defer cancel()
+	// Acquires the node before the admission, so that the node is not closed under the waiting call.
+	n.acquire()
+	defer n.release()
+
+	info.Node = n.name
+	info.Role = db.roleOf(n)
+	info.RowsAffected = -1
+	info.Caller = callerFrom(ctx)
+	info.Attempt = attemptFrom(ctx)
+	read := hedgeReadFrom(ctx)
+	info.Hedged = read.isHedged()
+	info.Dialect = db.dialect
+	db.admitBreaker(n, info)
+
+	var err error
+	called := 0
+	returned := false
+	// Calls After of the hooks even if fn panics or calls runtime.Goexit, such as the spans of the transactions.
+	defer func() {
+		if !returned {
+			if !info.Start.IsZero() {
+				info.Duration = time.Since(info.Start)
+			}
+			info.Err = errAborted
+		}
+		for i := called - 1; i >= 0; i-- {
+			db.hooks[i].After(ctx, info)
+		}
+		if returned {
+			db.checkSlowQuery(ctx, info, n)
+		}
+	}()
+	for _, h := range db.hooks {
+		var hctx context.Context
+		hctx, err = h.Before(ctx, info)
+		if err != nil {
+			break
+		}
+		if hctx != nil {
+			ctx = hctx
+		}
+		called++
+	}
+
+	info.Start = time.Now()
+	if err == nil {
+		var release func()
+		release, err = db.admit(ctx, info, n)
+		if err == nil {
+			info.Start = time.Now()
+			err = func() error {
+				// Releases the admission even if fn panics.
+				defer release()
+				return fn(ctx)
+			}()
+		}
+	}
+	info.Duration = time.Since(info.Start)
+	if err != nil && ctx.Err() == context.DeadlineExceeded {
+		err = &TimeoutError{Op: info.Op, Node: n.name, Timeout: timeout, Err: err}
+	}
+	if err != nil && read.isLost() {
+		err = ErrHedgeCanceled
+	}
+	info.Err = err
+	db.recordBreaker(n, info)
+	returned = true
+	return err
+}
@@ -0,0 +1,187 @@
+package sqlw_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/glassonion1/sqlw"
+	"github.com/glassonion1/sqlw/sqlwtest"
+)
+
+// hookRecorder records the calls passed to the hook.
+type hookRecorder struct {
+	mu    sync.Mutex
+	infos []sqlw.QueryInfo
+}
+
+func (r *hookRecorder) Before(ctx context.Context, info *sqlw.QueryInfo) (context.Context, error) {
+	return ctx, nil
+}
+
+func (r *hookRecorder) After(ctx context.Context, info *sqlw.QueryInfo) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.infos = append(r.infos, *info)
+}
+
+// summary returns op, query, node, role and whether in transaction of each call.
+func (r *hookRecorder) summary() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	list := []string{}
+	for _, info := range r.infos {
+		s := string(info.Op) + " " + info.Query + "@" + info.Node + "(" + string(info.Role) + ")"
+		if info.InTx {
+			s += " tx"
+		}
+		list = append(list, s)
+	}
+	return list
+}
+
+func TestDBUse(t *testing.T) {
+	c := sqlwtest.NewCluster(t)
+	db := sqlw.NewDB(c.Open(t, "master"), c.Open(t, "replica0"))
+	r := &hookRecorder{}
+	db.Use(r)
+	ctx := context.Background()
+
+	rows, err := db.Query(ctx, "SELECT * FROM users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows.Close()
+	if _, err := db.Exec(ctx, "DELETE FROM users"); err != nil {
+		t.Fatal(err)
+	}
+	err = db.Transaction(ctx, func(ctx context.Context, tx *sqlw.Tx) error {
+		_, err := tx.Exec(ctx, "UPDATE users SET name='foo'")
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.Transaction(ctx, func(ctx context.Context, tx *sqlw.Tx) error {
+		return errors.New("error")
+	})
+	if err == nil {
+		t.Fatal("should be error")
+	}
+
+	want := []string{
+		"query SELECT * FROM users@replica0(replica)",
+		"exec DELETE FROM users@master(master)",
+		"begin @master(master)",
+		"exec UPDATE users SET name='foo'@master(master) tx",
+		"commit @master(master) tx",
+		"transaction @master(master)",
+		"begin @master(master)",
+		"rollback @master(master) tx",
+		"transaction @master(master)",
+	}
+	if diff := cmp.Diff(r.summary(), want); diff != "" {
+		t.Errorf("failed to call hooks: %v", diff)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if got := r.infos[1].RowsAffected; got != 1 {
+		t.Errorf("should be 1 row affected but got: %d", got)
+	}
+	if got := r.infos[len(r.infos)-1].Err; got == nil {
+		t.Error("transaction should be failed")
+	}
+}
+
+func TestDBUseChain(t *testing.T) {
+	type key struct{}
+	c := sqlwtest.NewCluster(t)
+	db := sqlw.NewDB(c.Open(t, "master"))
+
+	calls := []string{}
+	errDenied := errors.New("denied")
+	db.Use(
+		sqlw.HookFuncs{
+			BeforeFunc: func(ctx context.Context, info *sqlw.QueryInfo) (context.Context, error) {
+				calls = append(calls, "before1")
+				return context.WithValue(ctx, key{}, "value"), nil
+			},
+			AfterFunc: func(ctx context.Context, info *sqlw.QueryInfo) {
+				calls = append(calls, "after1:"+info.Err.Error())
+			},
+		},
+		sqlw.HookFuncs{
+			BeforeFunc: func(ctx context.Context, info *sqlw.QueryInfo) (context.Context, error) {
+				calls = append(calls, "before2:"+ctx.Value(key{}).(string))
+				return ctx, errDenied
+			},
+			AfterFunc: func(ctx context.Context, info *sqlw.QueryInfo) {
+				calls = append(calls, "after2")
+			},
+		},
+	)
+
+	_, err := db.Exec(context.Background(), "DELETE FROM users")
+	if !errors.Is(err, errDenied) {
+		t.Errorf("should be error of %v but got: %v", errDenied, err)
+	}
+	if got := c.Stmts("master"); len(got) != 0 {
+		t.Errorf("should not execute: %v", got)
+	}
+	want := []string{"before1", "before2:value", "after1:denied"}
+	if diff := cmp.Diff(calls, want); diff != "" {
+		t.Errorf("failed to chain hooks: %v", diff)
+	}
+}
+
+func TestDBUseQueryRowRejected(t *testing.T) {
+	c := sqlwtest.NewCluster(t)
+	db := sqlw.NewDB(c.Open(t, "master"), c.Open(t, "replica0"))
+	errBlocked := errors.New("blocked")
+	blocked := false
+	db.Use(sqlw.HookFuncs{
+		BeforeFunc: func(ctx context.Context, info *sqlw.QueryInfo) (context.Context, error) {
+			if blocked {
+				return ctx, errBlocked
+			}
+			return ctx, nil
+		},
+	})
+	ctx := context.Background()
+
+	tx, err := db.Begin(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback(ctx)
+	blocked = true
+
+	rows := map[string]func() *sql.Row{
+		"QueryRow":          func() *sql.Row { return db.QueryRow(ctx, "SELECT 1") },
+		"QueryRowForMaster": func() *sql.Row { return db.QueryRowForMaster(ctx, "SELECT 1") },
+		"Tx.QueryRow":       func() *sql.Row { return tx.QueryRow(ctx, "SELECT 1") },
+	}
+	for name, fn := range rows {
+		row := fn()
+		if row == nil {
+			t.Errorf("%s should not return nil", name)
+			continue
+		}
+		var v int
+		if err := row.Scan(&v); !errors.Is(err, errBlocked) {
+			t.Errorf("%s should be error of %v but got: %v", name, errBlocked, err)
+		}
+	}
+	want := []string{"BEGIN"}
+	if diff := cmp.Diff(c.Stmts("master"), want); diff != "" {
+		t.Errorf("should not execute: %v", diff)
+	}
+	if got := c.Stmts("replica0"); len(got) != 0 {
+		t.Errorf("should not execute: %v", got)
+	}
+}
@@ -0,0 +1,198 @@
+package sqlw
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Priority is the lane of the calls in the admission limiter.
+type Priority int
+
+// The following priorities are supported.
+const (
+	// PriorityInteractive is the default priority for the user traffic.
+	PriorityInteractive Priority = iota
+	// PriorityBatch is the priority for the admin and batch jobs, such as backfills.
+	// The waiting interactive calls are admitted before the batch calls.
+	PriorityBatch
+)
+
+// String returns the name of the priority.
+func (p Priority) String() string {
+	switch p {
+	case PriorityInteractive:
+		return "interactive"
+	case PriorityBatch:
+		return "batch"
+	}
+	return fmt.Sprintf("priority(%d)", int(p))
+}
+
+type priorityKey struct{}
+
+// WithPriority returns a new context with the priority of the calls.
+func WithPriority(ctx context.Context, p Priority) context.Context {
+	return context.WithValue(ctx, priorityKey{}, p)
+}
+
+func priorityFrom(ctx context.Context) Priority {
+	if p, ok := ctx.Value(priorityKey{}).(Priority); ok && p == PriorityBatch {
+		return p
+	}
+	return PriorityInteractive
+}
+
+// OverloadedError is returned when the call is rejected by the admission limiter of the node.
+type OverloadedError struct {
+	Node     string
+	Priority Priority
+	// Reason is "queue full" or "wait timeout".
+	Reason string
+}
+
+func (e *OverloadedError) Error() string {
+	return fmt.Sprintf("%s is overloaded: %s for %s call", e.Node, e.Reason, e.Priority)
+}
+
+// LimitPolicy is the policy of the admission limiters of the nodes.
+type LimitPolicy struct {
+	// MaxInFlight is the max number of the calls running on the node.
+	MaxInFlight int
+	// MaxBatchInFlight is the max number of the batch calls running on the node, 0 means MaxInFlight.
+	// It keeps the rest for the interactive calls.
+	MaxBatchInFlight int
+	// MaxQueue is the max number of the calls waiting for the node.
+	MaxQueue int
+	// MaxWait is the max duration the call waits for the node, 0 means until the context is done.
+	MaxWait time.Duration
+}
+
+// SetLimitPolicy sets the policy of the admission limiters of the nodes, nil disables the admission limiters.
+//
+// The calls over MaxInFlight wait in the queue, and are rejected with *OverloadedError
+// if the queue is full or they wait longer than MaxWait.
+// A transaction is counted as a call, and the statements in the transaction are not limited.
+// The queries are counted until they return the rows, not until the rows are closed.
+func (db *DB) SetLimitPolicy(p *LimitPolicy) {
+	db.limitPolicy.Store(p)
+}
+
+func (db *DB) getLimitPolicy() *LimitPolicy {
+	p, _ := db.limitPolicy.Load().(*LimitPolicy)
+	return p
+}
+
+// limiter is the admission limiter of a node.
+type limiter struct {
+	mu       sync.Mutex
+	inFlight int
+	batch    int
+	// waiters are the waiting calls by the priority.
+	waiters [2][]chan struct{}
+}
+
+// waiting returns the number of the waiting calls.
+func (l *limiter) waiting() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.waiters[PriorityInteractive]) + len(l.waiters[PriorityBatch])
+}
+
+// canRun reports whether the call of the priority can run now.
+func (l *limiter) canRun(p *LimitPolicy, pr Priority) bool {
+	if l.inFlight >= p.MaxInFlight {
+		return false
+	}
+	return pr != PriorityBatch || p.MaxBatchInFlight <= 0 || l.batch < p.MaxBatchInFlight
+}
+
+func (l *limiter) start(pr Priority) {
+	l.inFlight++
+	if pr == PriorityBatch {
+		l.batch++
+	}
+}
+
+// acquire waits for the node to admit the call.
+func (l *limiter) acquire(ctx context.Context, p *LimitPolicy, name string, pr Priority) error {
+	l.mu.Lock()
+	// The new call does not overtake the waiting calls of the same or higher priority.
+	if len(l.waiters[PriorityInteractive]) == 0 && (pr == PriorityInteractive || len(l.waiters[PriorityBatch]) == 0) && l.canRun(p, pr) {
+		l.start(pr)
+		l.mu.Unlock()
+		return nil
+	}
+	if len(l.waiters[PriorityInteractive])+len(l.waiters[PriorityBatch]) >= p.MaxQueue {
+		l.mu.Unlock()
+		return &OverloadedError{Node: name, Priority: pr, Reason: "queue full"}
+	}
+	ch := make(chan struct{})
+	l.waiters[pr] = append(l.waiters[pr], ch)
+	l.mu.Unlock()
+
+	var timeout <-chan time.Time
+	if p.MaxWait > 0 {
+		timer := time.NewTimer(p.MaxWait)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	var err error
+	select {
+	case <-ch:
+		return nil
+	case <-timeout:
+		err = &OverloadedError{Node: name, Priority: pr, Reason: "wait timeout"}
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for i, w := range l.waiters[pr] {
+		if w == ch {
+			l.waiters[pr] = append(l.waiters[pr][:i], l.waiters[pr][i+1:]...)
+			return err
+		}
+	}
+	// The call is admitted just before giving up.
+	return nil
+}
+
+// release marks the end of the call, and admits the waiting calls.
+func (l *limiter) release(p *LimitPolicy, pr Priority) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.inFlight--
+	if pr == PriorityBatch {
+		l.batch--
+	}
+	for _, next := range []Priority{PriorityInteractive, PriorityBatch} {
+		for len(l.waiters[next]) > 0 && l.canRun(p, next) {
+			close(l.waiters[next][0])
+			l.waiters[next] = l.waiters[next][1:]
+			l.start(next)
+		}
+	}
+}
+
+// admit waits for the node to admit the call, and returns the function to release it.
+func (db *DB) admit(ctx context.Context, info *QueryInfo, n *node) (func(), error) {
+	p := db.getLimitPolicy()
+	if p == nil || info.InTx {
+		return func() {}, nil
+	}
+	switch info.Op {
+	case OpQuery, OpExec, OpPrepare, OpTransaction:
+	default:
+		return func() {}, nil
+	}
+	pr := priorityFrom(ctx)
+	if err := n.limiter.acquire(ctx, p, n.name, pr); err != nil {
+		return nil, err
+	}
+	return func() {
+		n.limiter.release(p, pr)
+	}, nil
+}
@@ -0,0 +1,206 @@
+package sqlw_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/glassonion1/sqlw"
+	"github.com/glassonion1/sqlw/sqlwtest"
+)
+
+func TestDBSetLimitPolicy(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy sqlw.LimitPolicy
+		want   string
+	}{
+		{
+			name:   "queue full",
+			policy: sqlw.LimitPolicy{MaxInFlight: 1, MaxQueue: 0},
+			want:   "queue full",
+		},
+		{
+			name:   "wait timeout",
+			policy: sqlw.LimitPolicy{MaxInFlight: 1, MaxQueue: 1, MaxWait: 10 * time.Millisecond},
+			want:   "wait timeout",
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			c := sqlwtest.NewCluster(t)
+			db := sqlw.NewDB(c.Open(t, "master"))
+			db.SetLimitPolicy(&tt.policy)
+			c.Update("master", func(n *sqlwtest.Node) {
+				n.Latency = 100 * time.Millisecond
+			})
+			ctx := context.Background()
+
+			done := make(chan error)
+			go func() {
+				_, err := db.Exec(ctx, "DELETE FROM users")
+				done <- err
+			}()
+			// Waits for the first call to run
+			for i := 0; i < 100 && len(c.Stmts("master")) == 0; i++ {
+				time.Sleep(time.Millisecond)
+			}
+
+			_, err := db.Exec(ctx, "DELETE FROM items")
+			var overloaded *sqlw.OverloadedError
+			if !errors.As(err, &overloaded) || overloaded.Reason != tt.want || overloaded.Node != "master" {
+				t.Errorf("should be overloaded by %s but got: %v", tt.want, err)
+			}
+			if got := sqlw.ClassifyError(err); got != sqlw.ErrorClassOverloaded {
+				t.Errorf("should be %s but got: %s", sqlw.ErrorClassOverloaded, got)
+			}
+			if err := <-done; err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestDBSetLimitPolicyPriority(t *testing.T) {
+	c := sqlwtest.NewCluster(t)
+	db := sqlw.NewDB(c.Open(t, "master"))
+	db.SetLimitPolicy(&sqlw.LimitPolicy{MaxInFlight: 1, MaxQueue: 10})
+	c.Update("master", func(n *sqlwtest.Node) {
+		n.Latency = 20 * time.Millisecond
+	})
+	ctx := context.Background()
+	batch := sqlw.WithPriority(ctx, sqlw.PriorityBatch)
+
+	var mu sync.Mutex
+	order := []string{}
+	var wg sync.WaitGroup
+	exec := func(ctx context.Context, q sqlw.SQLMutation) {
+		defer wg.Done()
+		if _, err := db.Exec(ctx, q); err != nil {
+			t.Error(err)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		order = append(order, q.String())
+	}
+	waitQueued := func(n int) {
+		for i := 0; i < 100 && db.Nodes()[0].Waiting < n; i++ {
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	wg.Add(4)
+	go exec(batch, "DELETE FROM batch0")
+	for i := 0; i < 100 && len(c.Stmts("master")) == 0; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	go exec(batch, "DELETE FROM batch1")
+	waitQueued(1)
+	go exec(batch, "DELETE FROM batch2")
+	waitQueued(2)
+	go exec(ctx, "DELETE FROM users")
+	waitQueued(3)
+	wg.Wait()
+
+	want := []string{"DELETE FROM batch0", "DELETE FROM users", "DELETE FROM batch1", "DELETE FROM batch2"}
+	if diff := cmp.Diff(order, want); diff != "" {
+		t.Errorf("interactive calls should be admitted first: %v", diff)
+	}
+}
+
+func TestDBSetLimitPolicyBatchInFlight(t *testing.T) {
+	c := sqlwtest.NewCluster(t)
+	db := sqlw.NewDB(c.Open(t, "master"))
+	db.SetLimitPolicy(&sqlw.LimitPolicy{MaxInFlight: 2, MaxBatchInFlight: 1})
+	c.Update("master", func(n *sqlwtest.Node) {
+		n.Latency = 50 * time.Millisecond
+	})
+	ctx := context.Background()
+	batch := sqlw.WithPriority(ctx, sqlw.PriorityBatch)
+
+	done := make(chan error)
+	go func() {
+		_, err := db.Exec(batch, "DELETE FROM batch0")
+		done <- err
+	}()
+	for i := 0; i < 100 && len(c.Stmts("master")) == 0; i++ {
+		time.Sleep(time.Millisecond)
+	}
+
+	var overloaded *sqlw.OverloadedError
+	if _, err := db.Exec(batch, "DELETE FROM batch1"); !errors.As(err, &overloaded) {
+		t.Errorf("batch call should be overloaded but got: %v", err)
+	}
+	if _, err := db.Exec(ctx, "DELETE FROM users"); err != nil {
+		t.Errorf("interactive call should be admitted but got: %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDBSetLimitPolicyTransaction(t *testing.T) {
+	c := sqlwtest.NewCluster(t)
+	db := sqlw.NewDB(c.Open(t, "master"))
+	db.SetLimitPolicy(&sqlw.LimitPolicy{MaxInFlight: 1})
+
+	err := db.Transaction(context.Background(), func(ctx context.Context, tx *sqlw.Tx) error {
+		_, err := tx.Exec(ctx, "DELETE FROM users")
+		return err
+	})
+	if err != nil {
+		t.Errorf("statements in transaction should not be limited: %v", err)
+	}
+}
+
+func TestDBSetLimitPolicyQueryRow(t *testing.T) {
+	c := sqlwtest.NewCluster(t)
+	db := sqlw.NewDB(c.Open(t, "master"))
+	db.SetLimitPolicy(&sqlw.LimitPolicy{MaxInFlight: 0, MaxQueue: 0})
+
+	var v int
+	err := db.QueryRowForMaster(context.Background(), "SELECT 1").Scan(&v)
+	var overloaded *sqlw.OverloadedError
+	if !errors.As(err, &overloaded) {
+		t.Errorf("should be overloaded but got: %v", err)
+	}
+}
+
+func TestDBSetLimitPolicyRemoveReplica(t *testing.T) {
+	c := sqlwtest.NewCluster(t)
+	db := sqlw.NewDB(c.Open(t, "master"), c.Open(t, "replica0"))
+	maxWait := 100 * time.Millisecond
+	db.SetLimitPolicy(&sqlw.LimitPolicy{MaxInFlight: 0, MaxQueue: 1, MaxWait: maxWait})
+	ctx := context.Background()
+
+	done := make(chan error)
+	go func() {
+		var node string
+		done <- db.QueryRow(ctx, "SELECT node").Scan(&node)
+	}()
+	for i := 0; i < 100 && db.Nodes()[1].Waiting == 0; i++ {
+		time.Sleep(time.Millisecond)
+	}
+
+	// The replica is not closed under the waiting call.
+	start := time.Now()
+	if err := db.RemoveReplica(ctx, "replica0"); err != nil {
+		t.Fatal(err)
+	}
+	if got := time.Since(start); got < maxWait/2 {
+		t.Errorf("should wait for the waiting call but got: %v", got)
+	}
+	var overloaded *sqlw.OverloadedError
+	if err := <-done; !errors.As(err, &overloaded) || overloaded.Reason != "wait timeout" {
+		t.Errorf("should be overloaded by wait timeout but got: %v", err)
+	}
+}
@@ -0,0 +1,246 @@
+package sqlw
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Level is the level of the log record.
+type Level int
+
+// The following levels are supported.
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+// String returns the name of the level.
+func (l Level) String() string {
+	switch l {
+	case LevelDebug:
+		return "DEBUG"
+	case LevelInfo:
+		return "INFO"
+	case LevelWarn:
+		return "WARN"
+	case LevelError:
+		return "ERROR"
+	}
+	return fmt.Sprintf("LEVEL(%d)", int(l))
+}
+
+// Logger writes the structured log records.
+type Logger interface {
+	Log(ctx context.Context, level Level, msg string, fields map[string]interface{})
+}
+
+// stdLogger writes the records with the standard logger.
+type stdLogger struct {
+	logger *log.Logger
+}
+
+// NewStdLogger returns a Logger that writes the records like "INFO msg key=value ..." with the standard logger.
+func NewStdLogger(l *log.Logger) Logger {
+	return &stdLogger{logger: l}
+}
+
+func (l *stdLogger) Log(ctx context.Context, level Level, msg string, fields map[string]interface{}) {
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	b.WriteString(level.String())
+	b.WriteString(" ")
+	b.WriteString(msg)
+	for _, k := range keys {
+		fmt.Fprintf(&b, " %s=%q", k, fmt.Sprint(fields[k]))
+	}
+	l.logger.Print(b.String())
+}
+
+// SetLogger sets the logger of the database, which logs the unexpected situations such as the transactions rolled back by WatchTxAge.
+// The default logger writes the records to the standard error.
+//
+// This function should be used outside of Goroutine.
+func (db *DB) SetLogger(l Logger) {
+	db.logger = l
+}
+
+func (db *DB) getLogger() Logger {
+	if db.logger == nil {
+		return defaultLogger
+	}
+	return db.logger
+}
+
+var defaultLogger = NewStdLogger(log.New(os.Stderr, "", log.LstdFlags))
+
+// Redacted replaces the values of the redacted args in the log records.
+const Redacted = "[REDACTED]"
+
+// DefaultRedactPatterns are the patterns of the columns whose values are not logged by default.
+var DefaultRedactPatterns = []string{"(?i)pass", "(?i)secret", "(?i)token", "(?i)credential"}
+
+// LogHook is a Hook that writes a log record for each call on the database.
+//
+// The record has the fields below.
+//
+//	op, statement, fingerprint, node, role, in_tx, caller, attempt, hedged, duration, rows_affected, args, error
+//
+// The statement is logged with the literals replaced by Sanitize, and the args are redacted by the redact patterns.
+// The calls that failed are logged at LevelError and always logged regardless of the sample rate,
+// except the hedged reads canceled by ErrHedgeCanceled, which are logged as the successful calls.
+type LogHook struct {
+	logger     Logger
+	mu         sync.Mutex
+	level      Level
+	sampleRate float64
+	logArgs    bool
+	redact     []*regexp.Regexp
+	rand       *rand.Rand
+}
+
+// NewLogHook returns a new LogHook that writes the records to the logger.
+// It logs the successful calls at LevelDebug with the args redacted by DefaultRedactPatterns.
+func NewLogHook(logger Logger) *LogHook {
+	h := &LogHook{
+		logger:     logger,
+		level:      LevelDebug,
+		sampleRate: 1,
+		logArgs:    true,
+		rand:       rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+	_ = h.SetRedactPatterns(DefaultRedactPatterns...)
+	return h
+}
+
+// SetLevel sets the level of the records of the successful calls.
+func (h *LogHook) SetLevel(l Level) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.level = l
+}
+
+// SetSampleRate sets the rate of the successful calls to be logged, from 0 to 1.
+func (h *LogHook) SetSampleRate(rate float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.sampleRate = rate
+}
+
+// SetLogArgs sets whether the args are logged.
+func (h *LogHook) SetLogArgs(enabled bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.logArgs = enabled
+}
+
+// SetRedactPatterns sets the regular expressions of the columns whose values are replaced with Redacted.
+// The column of an arg is found from the statement like "column = ?" or "INSERT INTO table(column) VALUES(?)".
+// The args whose column is unknown, such as the args of "INSERT INTO table VALUES(?)" and the named args, are also replaced with Redacted.
+func (h *LogHook) SetRedactPatterns(patterns ...string) error {
+	list := []*regexp.Regexp{}
+	for _, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return err
+		}
+		list = append(list, re)
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.redact = list
+	return nil
+}
+
+// Before implements Hook.
+func (h *LogHook) Before(ctx context.Context, info *QueryInfo) (context.Context, error) {
+	return ctx, nil
+}
+
+// After implements Hook.
+func (h *LogHook) After(ctx context.Context, info *QueryInfo) {
+	h.mu.Lock()
+	level := h.level
+	sampled := h.sampleRate >= 1 || h.rand.Float64() < h.sampleRate
+	logArgs := h.logArgs
+	redact := h.redact
+	h.mu.Unlock()
+
+	if info.Err != nil && !errors.Is(info.Err, ErrHedgeCanceled) {
+		level = LevelError
+	} else if !sampled {
+		return
+	}
+
+	fields := map[string]interface{}{
+		"op":       string(info.Op),
+		"node":     info.Node,
+		"role":     string(info.Role),
+		"in_tx":    info.InTx,
+		"duration": info.Duration,
+	}
+	if info.Query != "" {
+		fields["statement"] = info.Dialect.Sanitize(info.Query)
+		fields["fingerprint"] = info.Dialect.Fingerprint(info.Query)
+	}
+	if info.Caller != "" {
+		fields["caller"] = info.Caller
+	}
+	if info.Attempt > 1 {
+		fields["attempt"] = info.Attempt
+	}
+	if info.Hedged {
+		fields["hedged"] = true
+	}
+	if info.RowsAffected >= 0 {
+		fields["rows_affected"] = info.RowsAffected
+	}
+	if logArgs && len(info.Args) > 0 {
+		fields["args"] = redactArgs(info.Query, info.Dialect, info.Args, redact)
+	}
+	if info.Err != nil {
+		fields["error"] = info.Err.Error()
+	}
+	h.logger.Log(ctx, level, "sqlw: "+string(info.Op), fields)
+}
+
+// redactArgs replaces the args for the columns matching the patterns or the unknown columns with Redacted.
+func redactArgs(query string, d Dialect, args []interface{}, patterns []*regexp.Regexp) []interface{} {
+	columns := placeholderColumns(query, d)
+	list := make([]interface{}, len(args))
+	for i, arg := range args {
+		list[i] = Redacted
+		if _, ok := arg.(sql.NamedArg); ok || i >= len(columns) || columns[i] == "" {
+			continue
+		}
+		if !matchAny(patterns, columns[i]) {
+			list[i] = arg
+		}
+	}
+	return list
+}
+
+func matchAny(patterns []*regexp.Regexp, s string) bool {
+	for _, re := range patterns {
+		if re.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
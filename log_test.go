@@ -0,0 +1,217 @@
+package sqlw_test
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/glassonion1/sqlw"
+	"github.com/glassonion1/sqlw/sqlwtest"
+)
+
+// record is a log record.
+type record struct {
+	level  sqlw.Level
+	msg    string
+	fields map[string]interface{}
+}
+
+// memLogger keeps the log records in memory.
+type memLogger struct {
+	mu      sync.Mutex
+	records []record
+}
+
+func (l *memLogger) Log(ctx context.Context, level sqlw.Level, msg string, fields map[string]interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.records = append(l.records, record{level, msg, fields})
+}
+
+func TestLogHook(t *testing.T) {
+	c := sqlwtest.NewCluster(t)
+	db := sqlw.NewDB(c.Open(t, "master"), c.Open(t, "replica0"))
+	l := &memLogger{}
+	h := sqlw.NewLogHook(l)
+	h.SetLevel(sqlw.LevelInfo)
+	db.Use(h)
+	ctx := context.Background()
+
+	q := sqlw.SQLMutation("UPDATE users SET  name = 'foo', password = 'hunter2', token = ? WHERE id = ?")
+	if _, err := db.Exec(ctx, q, "p@ssw0rd", "id_0000"); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []record{
+		{
+			level: sqlw.LevelInfo,
+			msg:   "sqlw: exec",
+			fields: map[string]interface{}{
+				"op":            "exec",
+				"statement":     "update users set name = ? , password = ? , token = ? where id = ?",
+				"fingerprint":   "update users set name = ? , password = ? , token = ? where id = ?",
+				"node":          "master",
+				"role":          "master",
+				"in_tx":         false,
+				"rows_affected": int64(1),
+				"args":          []interface{}{sqlw.Redacted, "id_0000"},
+			},
+		},
+	}
+	for _, r := range l.records {
+		delete(r.fields, "duration")
+	}
+	if diff := cmp.Diff(l.records, want, cmp.AllowUnexported(record{})); diff != "" {
+		t.Errorf("failed to log: %v", diff)
+	}
+}
+
+func TestLogHookRedact(t *testing.T) {
+	tests := []struct {
+		name     string
+		patterns []string
+		dialect  sqlw.Dialect
+		in       sqlw.SQLMutation
+		args     []interface{}
+		want     []interface{}
+	}{
+		{
+			name: "insert",
+			in:   "INSERT INTO users(id, `password`, api_token) VALUES(?, ?, ?)",
+			args: []interface{}{"id_0000", "secret", "xxx"},
+			want: []interface{}{"id_0000", sqlw.Redacted, sqlw.Redacted},
+		},
+		{
+			name: "in list",
+			in:   "DELETE FROM sessions WHERE token IN (?, ?) AND user_id = ?",
+			args: []interface{}{"a", "b", "id_0000"},
+			want: []interface{}{sqlw.Redacted, sqlw.Redacted, "id_0000"},
+		},
+		{
+			name: "numbered placeholders",
+			in:   "UPDATE users SET secret = $2 WHERE id = $1",
+			args: []interface{}{"id_0000", "xxx"},
+			want: []interface{}{"id_0000", sqlw.Redacted},
+		},
+		{
+			name: "quoted identifiers",
+			in:   `UPDATE users SET "password" = $1 WHERE id = $2`,
+			args: []interface{}{"hunter2", 1},
+			want: []interface{}{sqlw.Redacted, 1},
+		},
+		{
+			name:    "quoted identifiers on postgres",
+			dialect: sqlw.DialectPostgres,
+			in:      `UPDATE users SET "password" = $1, "name" = $2 WHERE id = $3`,
+			args:    []interface{}{"hunter2", "foo", 1},
+			want:    []interface{}{sqlw.Redacted, "foo", 1},
+		},
+		{
+			name: "insert without columns",
+			in:   "INSERT INTO users VALUES (?, ?)",
+			args: []interface{}{"id_0000", "hunter2"},
+			want: []interface{}{sqlw.Redacted, sqlw.Redacted},
+		},
+		{
+			name: "named args",
+			in:   "UPDATE users SET name = @name WHERE id = @id",
+			args: []interface{}{sql.Named("name", "foo"), sql.Named("id", "id_0000")},
+			want: []interface{}{sqlw.Redacted, sqlw.Redacted},
+		},
+		{
+			name: "unknown columns",
+			in:   "SELECT * FROM users WHERE name LIKE CONCAT(?, '%') LIMIT ?",
+			args: []interface{}{"foo", 10},
+			want: []interface{}{sqlw.Redacted, sqlw.Redacted},
+		},
+		{
+			name:     "custom patterns",
+			patterns: []string{"^email$"},
+			in:       "UPDATE users SET email = ?, password = ?",
+			args:     []interface{}{"foo@example.com", "secret"},
+			want:     []interface{}{sqlw.Redacted, "secret"},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			c := sqlwtest.NewCluster(t)
+			db := sqlw.NewDB(c.Open(t, "master"))
+			if tt.dialect != "" {
+				db.SetDialect(tt.dialect)
+			}
+			l := &memLogger{}
+			h := sqlw.NewLogHook(l)
+			if tt.patterns != nil {
+				if err := h.SetRedactPatterns(tt.patterns...); err != nil {
+					t.Fatal(err)
+				}
+			}
+			db.Use(h)
+
+			if _, err := db.Exec(context.Background(), tt.in, tt.args...); err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(l.records[0].fields["args"], tt.want); diff != "" {
+				t.Errorf("failed to redact: %v", diff)
+			}
+		})
+	}
+}
+
+func TestLogHookSampling(t *testing.T) {
+	c := sqlwtest.NewCluster(t)
+	db := sqlw.NewDB(c.Open(t, "master"))
+	c.Update("master", func(n *sqlwtest.Node) {
+		n.Errs["DELETE FROM products"] = errors.New("Error 1146: Table 'app.products' doesn't exist")
+	})
+	l := &memLogger{}
+	h := sqlw.NewLogHook(l)
+	h.SetSampleRate(0)
+	h.SetLogArgs(false)
+	db.Use(h)
+	ctx := context.Background()
+
+	if _, err := db.Exec(ctx, "DELETE FROM users"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(ctx, "DELETE FROM products", 1); err == nil {
+		t.Fatal("should be error")
+	}
+	// The hedged read canceled by the other read is not an error.
+	h.After(ctx, &sqlw.QueryInfo{Op: sqlw.OpQuery, Query: "SELECT * FROM users", Err: sqlw.ErrHedgeCanceled})
+
+	if len(l.records) != 1 {
+		t.Fatalf("should log the error only: %v", l.records)
+	}
+	r := l.records[0]
+	if r.level != sqlw.LevelError || r.fields["error"] != "Error 1146: Table 'app.products' doesn't exist" {
+		t.Errorf("failed to log the error: %v", r)
+	}
+	if _, ok := r.fields["args"]; ok {
+		t.Errorf("should not log args: %v", r.fields)
+	}
+}
+
+func TestStdLogger(t *testing.T) {
+	buf := &bytes.Buffer{}
+	l := sqlw.NewStdLogger(log.New(buf, "", 0))
+	l.Log(context.Background(), sqlw.LevelWarn, "sqlw: query", map[string]interface{}{
+		"node": "replica0",
+		"op":   "query",
+	})
+	want := `WARN sqlw: query node="replica0" op="query"`
+	if got := strings.TrimSpace(buf.String()); got != want {
+		t.Errorf("should be %s but got: %s", want, got)
+	}
+}
@@ -0,0 +1,166 @@
+package sqlw
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// drainInterval is the interval for checking in-flight calls while draining a node.
+const drainInterval = 10 * time.Millisecond
+
+// node is a database of the cluster.
+type node struct {
+	name string
+	db   *sql.DB
+	// conf is the config the node is opened with by the connector, nil if the database is opened by the caller.
+	conf    *Config
+	active  int64
+	healthy int32
+	drained int32
+	breaker breaker
+	limiter limiter
+	stmts   stmtCache
+}
+
+func newNode(name string, db *sql.DB) *node {
+	return &node{
+		name:    name,
+		db:      db,
+		healthy: 1,
+	}
+}
+
+// isHealthy reports whether the node is selected for queries.
+func (n *node) isHealthy() bool {
+	return atomic.LoadInt32(&n.healthy) == 1
+}
+
+// isDrained reports whether the node is drained by the operator.
+func (n *node) isDrained() bool {
+	return atomic.LoadInt32(&n.drained) == 1
+}
+
+// setHealthy marks the node healthy or not, and reports whether it is changed.
+func (n *node) setHealthy(healthy bool) bool {
+	var v int32
+	if healthy {
+		v = 1
+	}
+	return atomic.SwapInt32(&n.healthy, v) != v
+}
+
+// acquire marks the start of a call on the node.
+func (n *node) acquire() {
+	atomic.AddInt64(&n.active, 1)
+}
+
+// release marks the end of a call on the node.
+func (n *node) release() {
+	atomic.AddInt64(&n.active, -1)
+}
+
+// drain waits for in-flight calls on the node to finish and closes it.
+// The node is closed even if the context is done before the calls finish.
+func (n *node) drain(ctx context.Context) error {
+	ticker := time.NewTicker(drainInterval)
+	defer ticker.Stop()
+
+	// Waits at least one interval for the calls that picked the node just before it was removed.
+	for {
+		select {
+		case <-ctx.Done():
+			n.stmts.trim(0)
+			if err := n.db.Close(); err != nil {
+				return err
+			}
+			return fmt.Errorf("failed to drain %s: %v", n.name, ctx.Err())
+		case <-ticker.C:
+		}
+		if atomic.LoadInt64(&n.active) == 0 {
+			n.stmts.trim(0)
+			return n.db.Close()
+		}
+	}
+}
+
+// NodeStatus is the status of a node for introspection.
+type NodeStatus struct {
+	Name    string `json:"name"`
+	Role    Role   `json:"role"`
+	Healthy bool   `json:"healthy"`
+	Drained bool   `json:"drained"`
+	// Breaker is the state of the circuit breaker.
+	Breaker BreakerState `json:"breaker"`
+	// InFlight is the number of calls running on the node, including the calls waiting for the admission limiter.
+	InFlight int64 `json:"in_flight"`
+	// Waiting is the number of calls waiting for the admission limiter.
+	Waiting int `json:"waiting"`
+	// Stmts is the number of the prepared statements in the statement cache.
+	Stmts int         `json:"stmts"`
+	Stats sql.DBStats `json:"stats"`
+}
+
+func (n *node) status(role Role) NodeStatus {
+	return NodeStatus{
+		Name:     n.name,
+		Role:     role,
+		Healthy:  n.isHealthy(),
+		Drained:  n.isDrained(),
+		Breaker:  n.breaker.getState(),
+		InFlight: atomic.LoadInt64(&n.active),
+		Waiting:  n.limiter.waiting(),
+		Stmts:    n.stmts.len(),
+		Stats:    n.db.Stats(),
+	}
+}
+
+// Nodes returns the status of the master, the replicas and the master candidates.
+func (db *DB) Nodes() []NodeStatus {
+	db.topoMu.RLock()
+	defer db.topoMu.RUnlock()
+
+	list := []NodeStatus{db.master.status(RoleMaster)}
+	for _, r := range db.readreplicas {
+		list = append(list, r.status(RoleReplica))
+	}
+	for _, c := range db.candidates {
+		list = append(list, c.status(RoleCandidate))
+	}
+	return list
+}
+
+// SetDrained drains the replica or brings it back.
+// The drained replica receives no new queries but stays open, unlike RemoveReplica.
+func (db *DB) SetDrained(name string, drained bool) error {
+	db.topoMu.RLock()
+	defer db.topoMu.RUnlock()
+
+	for _, r := range db.readreplicas {
+		if r.name == name {
+			var v int32
+			if drained {
+				v = 1
+			}
+			atomic.StoreInt32(&r.drained, v)
+			return nil
+		}
+	}
+	return fmt.Errorf("failed to drain %s: %w", name, ErrNodeNotFound)
+}
+
+// SetReadFromMaster forces the queries for the read replicas to be executed on the master.
+func (db *DB) SetReadFromMaster(enabled bool) {
+	var v int32
+	if enabled {
+		v = 1
+	}
+	atomic.StoreInt32(&db.readFromMaster, v)
+}
+
+// ReadFromMaster reports whether the queries for the read replicas are executed on the master.
+func (db *DB) ReadFromMaster() bool {
+	return atomic.LoadInt32(&db.readFromMaster) == 1
+}
@@ -0,0 +1,67 @@
+package sqlw_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/glassonion1/sqlw"
+	"github.com/glassonion1/sqlw/sqlwtest"
+)
+
+func TestDBSetDrained(t *testing.T) {
+	c := sqlwtest.NewCluster(t)
+	db := sqlw.NewDB(c.Open(t, "master"), c.Open(t, "replica0"), c.Open(t, "replica1"))
+
+	if err := db.SetDrained("replica0", true); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(queriedNodes(t, db, 50), map[string]bool{"replica1": true}); diff != "" {
+		t.Errorf("should not query the drained replica: %v", diff)
+	}
+
+	if err := db.SetDrained("replica0", false); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]bool{"replica0": true, "replica1": true}
+	if diff := cmp.Diff(queriedNodes(t, db, 100), want); diff != "" {
+		t.Errorf("should query the replica brought back: %v", diff)
+	}
+
+	if err := db.SetDrained("replica9", true); !errors.Is(err, sqlw.ErrNodeNotFound) {
+		t.Errorf("should be error of %v but got: %v", sqlw.ErrNodeNotFound, err)
+	}
+}
+
+func TestDBReadFromMaster(t *testing.T) {
+	c := sqlwtest.NewCluster(t)
+	db := sqlw.NewDB(c.Open(t, "master"), c.Open(t, "replica0"))
+
+	db.SetReadFromMaster(true)
+	if diff := cmp.Diff(queriedNodes(t, db, 10), map[string]bool{"master": true}); diff != "" {
+		t.Errorf("should query the master: %v", diff)
+	}
+
+	db.SetReadFromMaster(false)
+	if diff := cmp.Diff(queriedNodes(t, db, 10), map[string]bool{"replica0": true}); diff != "" {
+		t.Errorf("should query the replica: %v", diff)
+	}
+}
+
+func TestDBNodes(t *testing.T) {
+	c := sqlwtest.NewCluster(t)
+	db := sqlw.NewDB(c.Open(t, "master"), c.Open(t, "replica0"))
+	if err := db.AddMasterCandidate("standby", c.Open(t, "standby")); err != nil {
+		t.Fatal(err)
+	}
+
+	got := []sqlw.Role{}
+	for _, n := range db.Nodes() {
+		got = append(got, n.Role)
+	}
+	want := []sqlw.Role{sqlw.RoleMaster, sqlw.RoleReplica, sqlw.RoleCandidate}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("failed to list nodes: %v", diff)
+	}
+}
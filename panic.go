@@ -0,0 +1,61 @@
+package sqlw
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"sync/atomic"
+)
+
+// PanicError is the panic in TxHandlerFunc, returned by DB.Transaction if SetPanicAsError is enabled.
+type PanicError struct {
+	// Value is the value passed to panic.
+	Value interface{}
+	// Stack is the stack trace of the goroutine that panicked.
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic in transaction: %v", e.Value)
+}
+
+// SetPanicAsError sets whether the panic in TxHandlerFunc is returned as *PanicError.
+// The transaction is rolled back whether it is enabled or not, and the panic is propagated to the caller of DB.Transaction by default,
+// with *PanicError as the value of the panic.
+func (db *DB) SetPanicAsError(enabled bool) {
+	var v int32
+	if enabled {
+		v = 1
+	}
+	atomic.StoreInt32(&db.panicAsError, v)
+}
+
+// callTx calls fn, and returns *PanicError if fn panics.
+// If fn calls runtime.Goexit, the transaction is rolled back before the goroutine exits.
+func callTx(ctx context.Context, tx *Tx, fn TxHandlerFunc) (err error) {
+	returned := false
+	defer func() {
+		if returned {
+			return
+		}
+		if r := recover(); r != nil {
+			err = &PanicError{Value: r, Stack: debug.Stack()}
+			return
+		}
+		// runtime.Goexit
+		_ = tx.rollback(ctx)
+	}()
+	err = fn(ctx, tx)
+	returned = true
+	return err
+}
+
+// repanic panics again with *PanicError recovered in the transaction unless SetPanicAsError is enabled,
+// so that the stack trace of the original panic is kept in PanicError.Stack.
+func (db *DB) repanic(err error) {
+	var perr *PanicError
+	if errors.As(err, &perr) && atomic.LoadInt32(&db.panicAsError) == 0 {
+		panic(perr)
+	}
+}
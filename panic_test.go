@@ -0,0 +1,175 @@
+package sqlw_test
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/glassonion1/sqlw"
+	"github.com/glassonion1/sqlw/sqlwtest"
+)
+
+// assertTxEnded asserts that the transaction rolled back and the connection returned to the pool.
+func assertTxEnded(t *testing.T, c *sqlwtest.Cluster, db *sqlw.DB) {
+	t.Helper()
+	// database/sql rolls back the transaction of the canceled context asynchronously.
+	for i := 0; i < 100 && db.Nodes()[0].Stats.InUse > 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	want := []string{"BEGIN", "UPDATE users SET name='foo'", "ROLLBACK"}
+	if diff := cmp.Diff(c.Stmts("master"), want); diff != "" {
+		t.Errorf("failed to rollback: %v", diff)
+	}
+	if got := db.Nodes()[0].Stats.InUse; got != 0 {
+		t.Errorf("connection should be returned to the pool: %d", got)
+	}
+	// The next transaction is not blocked.
+	if err := db.Transaction(context.Background(), func(ctx context.Context, tx *sqlw.Tx) error {
+		return nil
+	}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestDBTransactionPanic(t *testing.T) {
+	c := sqlwtest.NewCluster(t)
+	db := sqlw.NewDB(c.Open(t, "master"))
+
+	var rollbackErr error
+	func() {
+		defer func() {
+			perr, ok := recover().(*sqlw.PanicError)
+			if !ok || perr.Value != "boom" {
+				t.Errorf("should panic with boom but got: %v", perr)
+				return
+			}
+			// The stack trace points at the panic in the transaction.
+			if !strings.Contains(string(perr.Stack), "TestDBTransactionPanic.func1.2") {
+				t.Errorf("should keep the stack trace of the panic: %s", perr.Stack)
+			}
+		}()
+		_ = db.Transaction(context.Background(), func(ctx context.Context, tx *sqlw.Tx) error {
+			tx.AfterRollback(func(ctx context.Context, err error) {
+				rollbackErr = err
+			})
+			if _, err := tx.Exec(ctx, "UPDATE users SET name='foo'"); err != nil {
+				return err
+			}
+			panic("boom")
+		})
+	}()
+
+	var perr *sqlw.PanicError
+	if !errors.As(rollbackErr, &perr) || perr.Value != "boom" {
+		t.Errorf("should be error of the panic but got: %v", rollbackErr)
+	}
+	assertTxEnded(t, c, db)
+}
+
+func TestDBTransactionPanicAsError(t *testing.T) {
+	c := sqlwtest.NewCluster(t)
+	db := sqlw.NewDB(c.Open(t, "master"))
+	db.SetPanicAsError(true)
+
+	err := db.Transaction(context.Background(), func(ctx context.Context, tx *sqlw.Tx) error {
+		if _, err := tx.Exec(ctx, "UPDATE users SET name='foo'"); err != nil {
+			return err
+		}
+		panic("boom")
+	})
+
+	var perr *sqlw.PanicError
+	if !errors.As(err, &perr) {
+		t.Fatalf("should be error of the panic but got: %v", err)
+	}
+	if perr.Value != "boom" || len(perr.Stack) == 0 {
+		t.Errorf("unexpected panic error: %v %s", perr.Value, perr.Stack)
+	}
+	if got := err.Error(); got != "panic in transaction: boom" {
+		t.Errorf("unexpected error: %s", got)
+	}
+	assertTxEnded(t, c, db)
+}
+
+func TestDBTransactionGoexit(t *testing.T) {
+	c := sqlwtest.NewCluster(t)
+	db := sqlw.NewDB(c.Open(t, "master"))
+	r := &hookRecorder{}
+	db.Use(r)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = db.Transaction(context.Background(), func(ctx context.Context, tx *sqlw.Tx) error {
+			if _, err := tx.Exec(ctx, "UPDATE users SET name='foo'"); err != nil {
+				return err
+			}
+			// Such as t.FailNow in the transaction.
+			runtime.Goexit()
+			return nil
+		})
+		t.Error("should not return")
+	}()
+	<-done
+
+	r.mu.Lock()
+	infos := append([]sqlw.QueryInfo{}, r.infos...)
+	r.mu.Unlock()
+	if len(infos) != 4 || infos[3].Op != sqlw.OpTransaction || infos[3].Err == nil {
+		t.Errorf("should call After of the transaction: %+v", infos)
+	}
+	assertTxEnded(t, c, db)
+}
+
+func TestDBTransactionCanceled(t *testing.T) {
+	tests := []struct {
+		name string
+		err  func(ctx context.Context) error
+	}{
+		{
+			name: "returns the error",
+			err:  func(ctx context.Context) error { return ctx.Err() },
+		},
+		{
+			name: "ignores the cancellation",
+			err:  func(ctx context.Context) error { return nil },
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			c := sqlwtest.NewCluster(t)
+			db := sqlw.NewDB(c.Open(t, "master"))
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			rolledBack := false
+			err := db.Transaction(ctx, func(ctx context.Context, tx *sqlw.Tx) error {
+				tx.AfterRollback(func(ctx context.Context, err error) {
+					rolledBack = true
+				})
+				if _, err := tx.Exec(ctx, "UPDATE users SET name='foo'"); err != nil {
+					return err
+				}
+				cancel()
+				<-ctx.Done()
+				return tt.err(ctx)
+			})
+			if err == nil {
+				t.Fatal("should be error")
+			}
+			if !rolledBack {
+				t.Error("should call the rollback callback")
+			}
+			assertTxEnded(t, c, db)
+		})
+	}
+}
@@ -0,0 +1,147 @@
+package sqlw_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/glassonion1/sqlw"
+	"github.com/glassonion1/sqlw/sqlwtest"
+)
+
+func TestDBTransactionTxReadOnly(t *testing.T) {
+	tests := []struct {
+		name string
+		opts *sql.TxOptions
+		want []string
+	}{
+		{
+			name: "read only",
+			opts: &sql.TxOptions{ReadOnly: true},
+			want: []string{"BEGIN READ ONLY", "SELECT node", "COMMIT"},
+		},
+		{
+			name: "consistent snapshot",
+			opts: &sql.TxOptions{ReadOnly: true, Isolation: sql.LevelSnapshot},
+			want: []string{"START TRANSACTION READ ONLY, WITH CONSISTENT SNAPSHOT", "SELECT node", "COMMIT"},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			c := sqlwtest.NewCluster(t)
+			db := sqlw.NewDB(c.Open(t, "master"), c.Open(t, "replica0"))
+			ctx := context.Background()
+
+			var name string
+			err := db.TransactionTx(ctx, func(ctx context.Context, tx *sqlw.Tx) error {
+				if err := tx.QueryRow(ctx, "SELECT node").Scan(&name); err != nil {
+					return err
+				}
+				if _, err := tx.Exec(ctx, "DELETE FROM users"); !errors.Is(err, sqlw.ErrReadOnlyTx) {
+					t.Errorf("should be error of %v but got: %v", sqlw.ErrReadOnlyTx, err)
+				}
+				return nil
+			}, tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if name != "replica0" {
+				t.Errorf("should be executed on the replica but got: %s", name)
+			}
+			if diff := cmp.Diff(c.Stmts("replica0"), tt.want); diff != "" {
+				t.Errorf("failed to execute transaction: %v", diff)
+			}
+			if got := c.Stmts("master"); len(got) != 0 {
+				t.Errorf("should not be executed on the master: %v", got)
+			}
+		})
+	}
+}
+
+func TestDBTransactionTxReadOnlyRollback(t *testing.T) {
+	c := sqlwtest.NewCluster(t)
+	db := sqlw.NewDB(c.Open(t, "master"), c.Open(t, "replica0"))
+	errReport := errors.New("report error")
+
+	err := db.TransactionTx(context.Background(), func(ctx context.Context, tx *sqlw.Tx) error {
+		return errReport
+	}, &sql.TxOptions{ReadOnly: true, Isolation: sql.LevelSnapshot})
+	if err == nil {
+		t.Fatal("should be error")
+	}
+	want := []string{"START TRANSACTION READ ONLY, WITH CONSISTENT SNAPSHOT", "ROLLBACK"}
+	if diff := cmp.Diff(c.Stmts("replica0"), want); diff != "" {
+		t.Errorf("failed to rollback: %v", diff)
+	}
+	if got := db.Nodes()[1].Stats.InUse; got != 0 {
+		t.Errorf("connection should be returned to the pool: %d", got)
+	}
+}
+
+func TestDBTransactionTxReadOnlyCommitError(t *testing.T) {
+	c := sqlwtest.NewCluster(t)
+	db := sqlw.NewDB(c.Open(t, "master"), c.Open(t, "replica0"))
+	errCommit := errors.New("commit failed")
+	c.Update("replica0", func(n *sqlwtest.Node) {
+		n.Errs["COMMIT"] = errCommit
+	})
+
+	err := db.TransactionTx(context.Background(), func(ctx context.Context, tx *sqlw.Tx) error {
+		return nil
+	}, &sql.TxOptions{ReadOnly: true, Isolation: sql.LevelSnapshot})
+	if !errors.Is(err, errCommit) {
+		t.Errorf("should be error of %v but got: %v", errCommit, err)
+	}
+	// The connection that may be left in the transaction is not returned to the pool.
+	if got := db.Nodes()[1].Stats.OpenConnections; got != 0 {
+		t.Errorf("connection should be discarded but got: %d", got)
+	}
+}
+
+func TestDBTransactionTxReadOnlyConcurrent(t *testing.T) {
+	c := sqlwtest.NewCluster(t)
+	db := sqlw.NewDB(c.Open(t, "master"), c.Open(t, "replica0"))
+	ctx := context.Background()
+
+	started := make(chan struct{})
+	finish := make(chan struct{})
+	done := make(chan error, 1)
+	go func() {
+		done <- db.TransactionTx(ctx, func(ctx context.Context, tx *sqlw.Tx) error {
+			close(started)
+			<-finish
+			return nil
+		}, &sql.TxOptions{ReadOnly: true})
+	}()
+	<-started
+
+	// The long read only transaction on the replica does not block the transactions on the master.
+	wrote := make(chan error, 1)
+	go func() {
+		wrote <- db.Transaction(ctx, func(ctx context.Context, tx *sqlw.Tx) error {
+			_, err := tx.Exec(ctx, "DELETE FROM users")
+			return err
+		})
+	}()
+	select {
+	case err := <-wrote:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(time.Second):
+		t.Error("the transaction on the master should not wait for the read only transaction")
+	}
+	close(finish)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
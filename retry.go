@@ -0,0 +1,57 @@
+package sqlw
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+// SetReadRetries sets the max number of the retries of the failed reads, 0 disables the retries.
+// The reads by Query and QueryRow failed with the connection errors or the default timeouts
+// are executed again on another healthy replica, and finally on the master.
+// The reads are never retried after the rows are returned, and QueryInfo.Attempt of the retries is more than 1.
+func (db *DB) SetReadRetries(n int) {
+	atomic.StoreInt32(&db.readRetries, int32(n))
+}
+
+// withReplica executes fn on a replica, and executes it again on another node if it fails with a transient error.
+// fn is hedged if it is enabled.
+func (db *DB) withReplica(ctx context.Context, query string, fn func(context.Context, *node) (interface{}, error)) (interface{}, error) {
+	n := db.getReplica()
+	var v interface{}
+	var err error
+	if delay, ok := db.hedge.hedgeDelay(ctx, query); ok {
+		v, err = db.withHedge(ctx, n, delay, fn)
+	} else {
+		start := time.Now()
+		v, err = fn(ctx, n)
+		if err == nil {
+			db.hedge.observe(time.Since(start))
+		}
+	}
+	retries := int(atomic.LoadInt32(&db.readRetries))
+	tried := []*node{n}
+	for attempt := 2; attempt <= retries+1 && isTransientReadError(ctx, err); attempt++ {
+		n = db.getReplicaExcept(tried)
+		if n == nil {
+			break
+		}
+		tried = append(tried, n)
+		v, err = fn(withAttempt(ctx, attempt), n)
+	}
+	return v, err
+}
+
+// isTransientReadError reports whether the read failed with the error can succeed on another node.
+func isTransientReadError(ctx context.Context, err error) bool {
+	if err == nil || ctx.Err() != nil {
+		return false
+	}
+	var timeoutErr *TimeoutError
+	if errors.As(err, &timeoutErr) {
+		// Retries only the timeouts by sqlw, the deadline of the caller is already exceeded.
+		return timeoutErr.Timeout > 0
+	}
+	return isConnError(err)
+}
@@ -0,0 +1,127 @@
+package sqlw_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/glassonion1/sqlw"
+	"github.com/glassonion1/sqlw/sqlwtest"
+)
+
+func TestDBSetReadRetries(t *testing.T) {
+	tests := []struct {
+		name    string
+		retries int
+		down    []string
+		want    []string
+		wantErr bool
+	}{
+		{
+			name:    "retries on another replica",
+			retries: 2,
+			down:    []string{"replica0"},
+			want:    []string{"replica0:1", "replica1:2"},
+		},
+		{
+			name:    "retries on the master finally",
+			retries: 2,
+			down:    []string{"replica0", "replica1"},
+			want:    []string{"replica0:1", "replica1:2", "master:3"},
+		},
+		{
+			name:    "gives up after the retries",
+			retries: 1,
+			down:    []string{"replica0", "replica1"},
+			want:    []string{"replica0:1", "replica1:2"},
+			wantErr: true,
+		},
+		{
+			name:    "disabled",
+			down:    []string{"replica0"},
+			want:    []string{"replica0:1"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			c := sqlwtest.NewCluster(t)
+			db := sqlw.NewDB(c.Open(t, "master"), c.Open(t, "replica0"), c.Open(t, "replica1"))
+			db.SetReadRetries(tt.retries)
+			// Makes replica0 be selected first
+			if err := db.SetDrained("replica1", true); err != nil {
+				t.Fatal(err)
+			}
+			got := []string{}
+			db.Use(sqlw.HookFuncs{
+				BeforeFunc: func(ctx context.Context, info *sqlw.QueryInfo) (context.Context, error) {
+					if info.Node == "replica0" {
+						// Makes replica1 be selected on the retry
+						_ = db.SetDrained("replica1", false)
+					}
+					return ctx, nil
+				},
+				AfterFunc: func(ctx context.Context, info *sqlw.QueryInfo) {
+					got = append(got, fmt.Sprintf("%s:%d", info.Node, info.Attempt))
+				},
+			})
+			for _, name := range tt.down {
+				c.Update(name, func(n *sqlwtest.Node) {
+					n.Down = true
+				})
+			}
+
+			var name string
+			err := db.QueryRow(context.Background(), "SELECT node FROM users").Scan(&name)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("error should be %v but got: %v", tt.wantErr, err)
+			}
+			if diff := cmp.Diff(got, tt.want); diff != "" {
+				t.Errorf("failed to retry: %v", diff)
+			}
+		})
+	}
+}
+
+func TestDBSetReadRetriesTimeout(t *testing.T) {
+	c := sqlwtest.NewCluster(t)
+	db := sqlw.NewDB(c.Open(t, "master"), c.Open(t, "replica0"))
+	db.SetReadRetries(1)
+	db.SetDefaultTimeouts(10*time.Millisecond, 0, 0)
+	c.Update("replica0", func(n *sqlwtest.Node) {
+		n.Latency = time.Second
+	})
+
+	rows, err := db.Query(context.Background(), "SELECT * FROM users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var name string
+	if !rows.Next() {
+		t.Fatal(rows.Err())
+	}
+	if err := rows.Scan(&name); err != nil {
+		t.Fatal(err)
+	}
+	if name != "master" {
+		t.Errorf("should retry on the master but got: %s", name)
+	}
+
+	// The deadline of the caller is not retried
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = db.Query(ctx, "SELECT * FROM users")
+	var timeoutErr *sqlw.TimeoutError
+	if !errors.As(err, &timeoutErr) || timeoutErr.Node != "replica0" {
+		t.Errorf("should be timeout error of replica0 but got: %v", err)
+	}
+}
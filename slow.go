@@ -0,0 +1,230 @@
+package sqlw
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// DefaultSlowQueryLogSize is the default number of the slow queries kept in memory.
+const DefaultSlowQueryLogSize = 100
+
+// explainTimeout is the timeout of EXPLAIN for the slow queries.
+const explainTimeout = 5 * time.Second
+
+// maxExplains is the max number of EXPLAIN for the slow queries running at the same time.
+const maxExplains = 4
+
+type callerKey struct{}
+
+// WithCaller returns a new context with the caller of the queries, such as the endpoint of the request.
+// The caller is set to QueryInfo.Caller and SlowQuery.Caller.
+func WithCaller(ctx context.Context, caller string) context.Context {
+	return context.WithValue(ctx, callerKey{}, caller)
+}
+
+func callerFrom(ctx context.Context) string {
+	caller, _ := ctx.Value(callerKey{}).(string)
+	return caller
+}
+
+// SlowQuery is a query that took longer than the slow query threshold.
+type SlowQuery struct {
+	// Query is the statement with the literals replaced by Sanitize.
+	Query string `json:"query"`
+	// Fingerprint and Digest group the same statements with the different values.
+	Fingerprint string `json:"fingerprint"`
+	Digest      string `json:"digest"`
+	// Args are redacted by DefaultRedactPatterns, and the args whose column is unknown are also redacted.
+	Args     []interface{} `json:"args,omitempty"`
+	Node     string        `json:"node"`
+	Role     Role          `json:"role"`
+	InTx     bool          `json:"in_tx"`
+	Caller   string        `json:"caller,omitempty"`
+	Start    time.Time     `json:"start"`
+	Duration time.Duration `json:"duration"`
+	Error    string        `json:"error,omitempty"`
+	// Plan is the result of EXPLAIN if it is enabled.
+	Plan         []map[string]string `json:"plan,omitempty"`
+	ExplainError string              `json:"explain_error,omitempty"`
+}
+
+// SlowQueryHandler handles the slow queries.
+// It is called synchronously, so it should not block.
+type SlowQueryHandler func(SlowQuery)
+
+// slowLog records the slow queries into the ring buffer.
+type slowLog struct {
+	mu        sync.Mutex
+	threshold time.Duration
+	explain   bool
+	handlers  []SlowQueryHandler
+	redact    []*regexp.Regexp
+	buf       []SlowQuery
+	next      int
+	full      bool
+	// explains is the semaphore of EXPLAIN running in the background.
+	explains chan struct{}
+}
+
+func newSlowLog() *slowLog {
+	l := &slowLog{
+		buf:      make([]SlowQuery, DefaultSlowQueryLogSize),
+		explains: make(chan struct{}, maxExplains),
+	}
+	for _, p := range DefaultRedactPatterns {
+		l.redact = append(l.redact, regexp.MustCompile(p))
+	}
+	return l
+}
+
+// SetSlowQueryThreshold sets the threshold of the slow queries, 0 disables the detection.
+func (db *DB) SetSlowQueryThreshold(d time.Duration) {
+	db.slow.mu.Lock()
+	defer db.slow.mu.Unlock()
+	db.slow.threshold = d
+}
+
+// SetSlowQueryExplain sets whether EXPLAIN of the slow queries is captured.
+// EXPLAIN is executed on the same node after the query in a new goroutine,
+// then the slow query is recorded.
+// Only SELECT, INSERT, UPDATE, DELETE and REPLACE are explained,
+// and EXPLAIN is skipped while too many EXPLAIN are running.
+func (db *DB) SetSlowQueryExplain(enabled bool) {
+	db.slow.mu.Lock()
+	defer db.slow.mu.Unlock()
+	db.slow.explain = enabled
+}
+
+// SetSlowQueryLogSize sets the number of the slow queries kept in memory.
+// The recorded slow queries are discarded.
+func (db *DB) SetSlowQueryLogSize(n int) {
+	if n < 1 {
+		n = 1
+	}
+	db.slow.mu.Lock()
+	defer db.slow.mu.Unlock()
+	db.slow.buf = make([]SlowQuery, n)
+	db.slow.next = 0
+	db.slow.full = false
+}
+
+// OnSlowQuery registers the slow query handler.
+//
+// This function should be used outside of Goroutine.
+func (db *DB) OnSlowQuery(h SlowQueryHandler) {
+	db.slow.handlers = append(db.slow.handlers, h)
+}
+
+// SlowQueries returns the recorded slow queries from oldest to newest.
+func (db *DB) SlowQueries() []SlowQuery {
+	db.slow.mu.Lock()
+	defer db.slow.mu.Unlock()
+	list := []SlowQuery{}
+	if db.slow.full {
+		list = append(list, db.slow.buf[db.slow.next:]...)
+	}
+	return append(list, db.slow.buf[:db.slow.next]...)
+}
+
+// checkSlowQuery records the query if it is slower than the threshold.
+func (db *DB) checkSlowQuery(ctx context.Context, info *QueryInfo, n *node) {
+	if info.Op != OpQuery && info.Op != OpExec {
+		return
+	}
+	db.slow.mu.Lock()
+	threshold, explain, redact := db.slow.threshold, db.slow.explain, db.slow.redact
+	db.slow.mu.Unlock()
+	if threshold <= 0 || info.Duration < threshold {
+		return
+	}
+
+	q := SlowQuery{
+		Query:       info.Dialect.Sanitize(info.Query),
+		Fingerprint: info.Dialect.Fingerprint(info.Query),
+		Digest:      info.Dialect.Digest(info.Query),
+		Node:        info.Node,
+		Role:        info.Role,
+		InTx:        info.InTx,
+		Caller:      info.Caller,
+		Start:       info.Start,
+		Duration:    info.Duration,
+	}
+	if len(info.Args) > 0 {
+		q.Args = redactArgs(info.Query, info.Dialect, info.Args, redact)
+	}
+	if info.Err != nil {
+		q.Error = info.Err.Error()
+	}
+	if !explain || StatementKind(info.Query) == "other" {
+		db.recordSlowQuery(q)
+		return
+	}
+	select {
+	case db.slow.explains <- struct{}{}:
+	default:
+		q.ExplainError = "explain skipped: too many explains in progress"
+		db.recordSlowQuery(q)
+		return
+	}
+	query, args := info.Query, append([]interface{}{}, info.Args...)
+	go func() {
+		defer func() { <-db.slow.explains }()
+		ctx, cancel := context.WithTimeout(context.Background(), explainTimeout)
+		defer cancel()
+		plan, err := explainOn(ctx, n.db, query, args...)
+		if err != nil {
+			q.ExplainError = err.Error()
+		}
+		q.Plan = plan
+		db.recordSlowQuery(q)
+	}()
+}
+
+func (db *DB) recordSlowQuery(q SlowQuery) {
+	db.slow.mu.Lock()
+	db.slow.buf[db.slow.next] = q
+	db.slow.next = (db.slow.next + 1) % len(db.slow.buf)
+	if db.slow.next == 0 {
+		db.slow.full = true
+	}
+	db.slow.mu.Unlock()
+
+	for _, h := range db.slow.handlers {
+		h(q)
+	}
+}
+
+// explainOn executes EXPLAIN of the query and returns the rows as the maps of the columns.
+func explainOn(ctx context.Context, db *sql.DB, query string, args ...interface{}) ([]map[string]string, error) {
+	rows, err := db.QueryContext(ctx, "EXPLAIN "+query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to explain: %w", err)
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	plan := []map[string]string{}
+	for rows.Next() {
+		values := make([]sql.NullString, len(columns))
+		dest := make([]interface{}, len(columns))
+		for i := range values {
+			dest[i] = &values[i]
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		row := map[string]string{}
+		for i, c := range columns {
+			row[c] = values[i].String
+		}
+		plan = append(plan, row)
+	}
+	return plan, rows.Err()
+}
@@ -0,0 +1,194 @@
+package sqlw_test
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+
+	"github.com/glassonion1/sqlw"
+	"github.com/glassonion1/sqlw/sqlwtest"
+)
+
+func TestDBSlowQueries(t *testing.T) {
+	c := sqlwtest.NewCluster(t)
+	db := sqlw.NewDB(c.Open(t, "master"), c.Open(t, "replica0"))
+	db.SetSlowQueryThreshold(20 * time.Millisecond)
+	db.SetSlowQueryLogSize(2)
+	handled := []string{}
+	db.OnSlowQuery(func(q sqlw.SlowQuery) {
+		handled = append(handled, q.Query)
+	})
+	ctx := sqlw.WithCaller(context.Background(), "POST /users")
+
+	if _, err := db.Exec(ctx, "UPDATE users SET name = ?", "foo"); err != nil {
+		t.Fatal(err)
+	}
+	if got := db.SlowQueries(); len(got) != 0 {
+		t.Errorf("should not record fast queries: %v", got)
+	}
+
+	c.Update("master", func(n *sqlwtest.Node) {
+		n.Latency = 30 * time.Millisecond
+	})
+	calls := []struct {
+		query sqlw.SQLMutation
+		args  []interface{}
+	}{
+		{query: "DELETE FROM users"},
+		{query: "UPDATE users SET name = ?, password = 'hunter2' WHERE id = ?", args: []interface{}{"foo", "id_0000"}},
+		{query: "DELETE FROM products"},
+	}
+	for _, call := range calls {
+		if _, err := db.Exec(ctx, call.query, call.args...); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := []sqlw.SlowQuery{
+		{
+			Query:       "update users set name = ? , password = ? where id = ?",
+			Fingerprint: "update users set name = ? , password = ? where id = ?",
+			Digest:      sqlw.Digest("UPDATE users SET name = ?, password = ? WHERE id = ?"),
+			Args:        []interface{}{"foo", "id_0000"},
+			Node:        "master",
+			Role:        sqlw.RoleMaster,
+			Caller:      "POST /users",
+		},
+		{
+			Query:       "delete from products",
+			Fingerprint: "delete from products",
+			Digest:      sqlw.Digest("DELETE FROM products"),
+			Node:        "master",
+			Role:        sqlw.RoleMaster,
+			Caller:      "POST /users",
+		},
+	}
+	got := db.SlowQueries()
+	if diff := cmp.Diff(got, want, cmpopts.IgnoreFields(sqlw.SlowQuery{}, "Start", "Duration")); diff != "" {
+		t.Errorf("failed to record slow queries: %v", diff)
+	}
+	for _, q := range got {
+		if q.Duration < 20*time.Millisecond {
+			t.Errorf("should be slower than the threshold: %v", q.Duration)
+		}
+	}
+	if len(handled) != 3 {
+		t.Errorf("should handle all slow queries: %v", handled)
+	}
+}
+
+func TestDBSlowQueriesExplain(t *testing.T) {
+	c := sqlwtest.NewCluster(t)
+	db := sqlw.NewDB(c.Open(t, "master"), c.Open(t, "replica0"))
+	db.SetSlowQueryThreshold(10 * time.Millisecond)
+	db.SetSlowQueryExplain(true)
+	ch := make(chan sqlw.SlowQuery, 1)
+	db.OnSlowQuery(func(q sqlw.SlowQuery) {
+		ch <- q
+	})
+	c.Update("replica0", func(n *sqlwtest.Node) {
+		n.Latency = 20 * time.Millisecond
+	})
+
+	rows, err := db.Query(context.Background(), "SELECT * FROM users WHERE id = ?", "id_0000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows.Close()
+
+	select {
+	case q := <-ch:
+		if diff := cmp.Diff(q.Plan, []map[string]string{{"node": "replica0"}}); diff != "" {
+			t.Errorf("failed to explain: %v", diff)
+		}
+		if q.ExplainError != "" {
+			t.Errorf("should not be error: %v", q.ExplainError)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("slow query is not reported")
+	}
+
+	want := []string{"SELECT * FROM users WHERE id = ?", "EXPLAIN SELECT * FROM users WHERE id = ?"}
+	if diff := cmp.Diff(c.Stmts("replica0"), want); diff != "" {
+		t.Errorf("should explain on the same node: %v", diff)
+	}
+}
+
+func TestDBSlowQueriesExplainSkipped(t *testing.T) {
+	c := sqlwtest.NewCluster(t)
+	db := sqlw.NewDB(c.Open(t, "master"), c.Open(t, "replica0"))
+	db.SetSlowQueryThreshold(10 * time.Millisecond)
+	db.SetSlowQueryExplain(true)
+	ch := make(chan sqlw.SlowQuery, 20)
+	db.OnSlowQuery(func(q sqlw.SlowQuery) {
+		ch <- q
+	})
+	c.Update("master", func(n *sqlwtest.Node) {
+		n.Latency = 50 * time.Millisecond
+	})
+	c.Update("replica0", func(n *sqlwtest.Node) {
+		n.Latency = 50 * time.Millisecond
+	})
+	ctx := context.Background()
+
+	// The statements other than DML are not explained.
+	err := db.Transaction(ctx, func(ctx context.Context, tx *sqlw.Tx) error {
+		return tx.Transaction(ctx, func(ctx context.Context, tx *sqlw.Tx) error {
+			return nil
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"savepoint sqlw_savepoint_1", "release savepoint sqlw_savepoint_1"} {
+		select {
+		case q := <-ch:
+			if q.Query != want || q.Plan != nil || q.ExplainError != "" {
+				t.Errorf("should not explain %s: %+v", want, q)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("slow query is not reported")
+		}
+	}
+
+	// EXPLAIN is skipped while too many EXPLAIN are running.
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rows, err := db.Query(ctx, "SELECT * FROM users")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			rows.Close()
+		}()
+	}
+	wg.Wait()
+	skipped := 0
+	for i := 0; i < 10; i++ {
+		select {
+		case q := <-ch:
+			if q.Plan == nil {
+				skipped++
+			}
+		case <-time.After(time.Second):
+			t.Fatal("slow query is not reported")
+		}
+	}
+	explained := 0
+	for _, s := range c.Stmts("replica0") {
+		if strings.HasPrefix(s, "EXPLAIN") {
+			explained++
+		}
+	}
+	if skipped == 0 || explained+skipped != 10 {
+		t.Errorf("should skip EXPLAIN: explained %d, skipped %d", explained, skipped)
+	}
+}
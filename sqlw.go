@@ -1,14 +1,18 @@
 package sqlw
 
-import "fmt"
+import (
+	"database/sql"
+	"fmt"
+	"net"
+)
 
 // Config holds the database configuration information.
 type Config struct {
-	User     string
-	Password string
-	Host     string
-	Port     string
-	DBName   string
+	User     string `json:"user" yaml:"user"`
+	Password string `json:"password" yaml:"password"`
+	Host     string `json:"host" yaml:"host"`
+	Port     string `json:"port" yaml:"port"`
+	DBName   string `json:"dbname" yaml:"dbname"`
 }
 
 func (c Config) mysqlStr() string {
@@ -20,3 +24,21 @@ func (c Config) postgresStr() string {
 	return fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
 		c.Host, c.Port, c.User, c.Password, c.DBName)
 }
+
+// name returns the node name of the database, that is host:port.
+func (c Config) name() string {
+	return net.JoinHostPort(c.Host, c.Port)
+}
+
+// Connector opens a database described by the config.
+type Connector func(Config) (*sql.DB, error)
+
+// MySQLConnector opens a MySQL database.
+func MySQLConnector(c Config) (*sql.DB, error) {
+	return sql.Open("mysql", c.mysqlStr())
+}
+
+// PostgresConnector opens a PostgreSQL database.
+func PostgresConnector(c Config) (*sql.DB, error) {
+	return sql.Open("postgres", c.postgresStr())
+}
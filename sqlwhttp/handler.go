@@ -0,0 +1,225 @@
+// Package sqlwhttp provides the http handler for the health checks and the administration of sqlw.DB.
+package sqlwhttp
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/glassonion1/sqlw"
+)
+
+// ErrAdminDisabled is returned for the admin actions when no authorizer is set.
+var ErrAdminDisabled = errors.New("admin actions are disabled")
+
+// Authorizer authorizes the request for the admin actions.
+// The request is rejected if it returns an error.
+type Authorizer func(*http.Request) error
+
+// Handler serves the health checks and the admin actions of the database.
+//
+//	GET  /livez                      200 if the database is readable
+//	GET  /readyz                     200 if the database is readable, writable and not in maintenance
+//	GET  /nodes                      status of the nodes
+//	GET  /health                     health report of the nodes
+//	POST /admin/drain?node=replica0  drains the replica, enabled=false brings it back
+//	POST /admin/read-from-master     forces reads to the master, enabled=false stops it
+//	POST /admin/maintenance          enters maintenance mode, enabled=false leaves it
+//	GET  /admin/slow-queries         recorded slow queries
+//
+// The admin actions are disabled until an authorizer is set.
+type Handler struct {
+	db          *sqlw.DB
+	mux         *http.ServeMux
+	mu          sync.Mutex
+	authorizer  Authorizer
+	maintenance int32
+}
+
+// NewHandler returns a new handler for the database.
+func NewHandler(db *sqlw.DB) *Handler {
+	h := &Handler{
+		db:  db,
+		mux: http.NewServeMux(),
+	}
+	h.mux.HandleFunc("/livez", h.get(h.livez))
+	h.mux.HandleFunc("/readyz", h.get(h.readyz))
+	h.mux.HandleFunc("/nodes", h.get(h.nodes))
+	h.mux.HandleFunc("/health", h.get(h.health))
+	h.mux.HandleFunc("/admin/drain", h.admin(h.drain))
+	h.mux.HandleFunc("/admin/read-from-master", h.admin(h.readFromMaster))
+	h.mux.HandleFunc("/admin/maintenance", h.admin(h.setMaintenance))
+	h.mux.HandleFunc("/admin/slow-queries", h.get(h.authorize(h.slowQueries)))
+	return h
+}
+
+// SetAuthorizer sets the authorizer for the admin actions.
+func (h *Handler) SetAuthorizer(a Authorizer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.authorizer = a
+}
+
+// Maintenance reports whether the handler is in maintenance mode.
+func (h *Handler) Maintenance() bool {
+	return atomic.LoadInt32(&h.maintenance) == 1
+}
+
+// ServeHTTP implements http.Handler.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mux.ServeHTTP(w, r)
+}
+
+// status is the response of the checks and the actions.
+type status struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeStatus(w http.ResponseWriter, code int, err error) {
+	if err != nil {
+		writeJSON(w, code, status{Status: "error", Error: err.Error()})
+		return
+	}
+	writeJSON(w, code, status{Status: "ok"})
+}
+
+func (h *Handler) get(fn http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			writeStatus(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+			return
+		}
+		fn(w, r)
+	}
+}
+
+func (h *Handler) authorize(fn http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		h.mu.Lock()
+		authorizer := h.authorizer
+		h.mu.Unlock()
+		if authorizer == nil {
+			writeStatus(w, http.StatusForbidden, ErrAdminDisabled)
+			return
+		}
+		if err := authorizer(r); err != nil {
+			writeStatus(w, http.StatusForbidden, err)
+			return
+		}
+		fn(w, r)
+	}
+}
+
+func (h *Handler) admin(fn func(*http.Request) error) http.HandlerFunc {
+	action := h.authorize(func(w http.ResponseWriter, r *http.Request) {
+		if err := fn(r); err != nil {
+			writeStatus(w, http.StatusBadRequest, err)
+			return
+		}
+		writeStatus(w, http.StatusOK, nil)
+	})
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeStatus(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+			return
+		}
+		action(w, r)
+	}
+}
+
+func (h *Handler) livez(w http.ResponseWriter, r *http.Request) {
+	if err := h.db.ReadableContext(r.Context()); err != nil {
+		writeStatus(w, http.StatusServiceUnavailable, err)
+		return
+	}
+	writeStatus(w, http.StatusOK, nil)
+}
+
+func (h *Handler) readyz(w http.ResponseWriter, r *http.Request) {
+	if h.Maintenance() {
+		writeStatus(w, http.StatusServiceUnavailable, errors.New("maintenance mode"))
+		return
+	}
+	if err := h.db.ReadableContext(r.Context()); err != nil {
+		writeStatus(w, http.StatusServiceUnavailable, err)
+		return
+	}
+	if err := h.db.WritableContext(r.Context()); err != nil {
+		writeStatus(w, http.StatusServiceUnavailable, err)
+		return
+	}
+	writeStatus(w, http.StatusOK, nil)
+}
+
+func (h *Handler) nodes(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, h.db.Nodes())
+}
+
+func (h *Handler) health(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, h.db.Health(r.Context()))
+}
+
+func (h *Handler) slowQueries(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, h.db.SlowQueries())
+}
+
+// enabled parses the "enabled" query parameter, it is true if omitted.
+func enabled(r *http.Request) (bool, error) {
+	v := r.URL.Query().Get("enabled")
+	if v == "" {
+		return true, nil
+	}
+	return strconv.ParseBool(v)
+}
+
+func (h *Handler) drain(r *http.Request) error {
+	on, err := enabled(r)
+	if err != nil {
+		return err
+	}
+	name := r.URL.Query().Get("node")
+	if name == "" {
+		return errors.New("node is required")
+	}
+	return h.db.SetDrained(name, on)
+}
+
+func (h *Handler) readFromMaster(r *http.Request) error {
+	on, err := enabled(r)
+	if err != nil {
+		return err
+	}
+	h.db.SetReadFromMaster(on)
+	return nil
+}
+
+func (h *Handler) setMaintenance(r *http.Request) error {
+	on, err := enabled(r)
+	if err != nil {
+		return err
+	}
+	var v int32
+	if on {
+		v = 1
+	}
+	atomic.StoreInt32(&h.maintenance, v)
+	return nil
+}
+
+// Middleware sets the method and the path of the request as the caller of the queries by sqlw.WithCaller.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := sqlw.WithCaller(r.Context(), r.Method+" "+r.URL.Path)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
@@ -0,0 +1,257 @@
+package sqlwhttp_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/glassonion1/sqlw"
+	"github.com/glassonion1/sqlw/sqlwhttp"
+	"github.com/glassonion1/sqlw/sqlwtest"
+)
+
+func serve(t *testing.T, h http.Handler, method, target string) (int, map[string]interface{}) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(method, target, nil))
+	body := map[string]interface{}{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("invalid json %q: %v", rec.Body.String(), err)
+	}
+	return rec.Code, body
+}
+
+func TestHandlerChecks(t *testing.T) {
+	tests := []struct {
+		name     string
+		target   string
+		down     []string
+		readOnly []string
+		want     int
+	}{
+		{
+			name:   "live",
+			target: "/livez",
+			want:   http.StatusOK,
+		},
+		{
+			name:   "not live",
+			target: "/livez",
+			down:   []string{"replica0"},
+			want:   http.StatusServiceUnavailable,
+		},
+		{
+			name:   "ready",
+			target: "/readyz",
+			want:   http.StatusOK,
+		},
+		{
+			name:     "not ready on read only master",
+			target:   "/readyz",
+			readOnly: []string{"master"},
+			want:     http.StatusServiceUnavailable,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			c := sqlwtest.NewCluster(t)
+			db := sqlw.NewDB(c.Open(t, "master"), c.Open(t, "replica0"))
+			for _, name := range tt.down {
+				c.Update(name, func(n *sqlwtest.Node) {
+					n.Down = true
+				})
+			}
+			for _, name := range tt.readOnly {
+				c.Update(name, func(n *sqlwtest.Node) {
+					n.ReadOnly = true
+				})
+			}
+
+			got, body := serve(t, sqlwhttp.NewHandler(db), http.MethodGet, tt.target)
+			if got != tt.want {
+				t.Errorf("should be status %d but got: %d, %v", tt.want, got, body)
+			}
+		})
+	}
+}
+
+func TestHandlerNodes(t *testing.T) {
+	c := sqlwtest.NewCluster(t)
+	db := sqlw.NewDB(c.Open(t, "master"), c.Open(t, "replica0"))
+	h := sqlwhttp.NewHandler(db)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/nodes", nil))
+	got := []sqlw.NodeStatus{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for _, n := range got {
+		names = append(names, n.Name)
+	}
+	if diff := cmp.Diff(names, []string{"master", "replica0"}); diff != "" {
+		t.Errorf("failed to list nodes: %v", diff)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/health", nil))
+	report := sqlw.HealthReport{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Nodes) != 2 || !report.Nodes[0].Healthy || !report.Nodes[1].Healthy {
+		t.Errorf("failed to report health: %v", report)
+	}
+}
+
+func TestHandlerAdmin(t *testing.T) {
+	c := sqlwtest.NewCluster(t)
+	db := sqlw.NewDB(c.Open(t, "master"), c.Open(t, "replica0"))
+	h := sqlwhttp.NewHandler(db)
+
+	if got, _ := serve(t, h, http.MethodPost, "/admin/maintenance"); got != http.StatusForbidden {
+		t.Errorf("admin should be disabled without authorizer: %d", got)
+	}
+
+	h.SetAuthorizer(func(r *http.Request) error {
+		if r.Header.Get("X-Admin-Token") != "secret" {
+			return errors.New("unauthorized")
+		}
+		return nil
+	})
+	if got, _ := serve(t, h, http.MethodPost, "/admin/maintenance"); got != http.StatusForbidden {
+		t.Errorf("admin should be rejected by authorizer: %d", got)
+	}
+
+	authed := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Header.Set("X-Admin-Token", "secret")
+		h.ServeHTTP(w, r)
+	})
+
+	tests := []struct {
+		name   string
+		target string
+		want   int
+		check  func() bool
+	}{
+		{
+			name:   "enters maintenance mode",
+			target: "/admin/maintenance",
+			want:   http.StatusOK,
+			check:  h.Maintenance,
+		},
+		{
+			name:   "leaves maintenance mode",
+			target: "/admin/maintenance?enabled=false",
+			want:   http.StatusOK,
+			check: func() bool {
+				return !h.Maintenance()
+			},
+		},
+		{
+			name:   "forces reads to master",
+			target: "/admin/read-from-master",
+			want:   http.StatusOK,
+			check:  db.ReadFromMaster,
+		},
+		{
+			name:   "drains replica",
+			target: "/admin/drain?node=replica0",
+			want:   http.StatusOK,
+			check: func() bool {
+				return db.Nodes()[1].Drained
+			},
+		},
+		{
+			name:   "drains unknown replica",
+			target: "/admin/drain?node=replica9",
+			want:   http.StatusBadRequest,
+			check: func() bool {
+				return true
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		got, body := serve(t, authed, http.MethodPost, tt.target)
+		if got != tt.want {
+			t.Errorf("%s: should be status %d but got: %d, %v", tt.name, tt.want, got, body)
+		}
+		if !tt.check() {
+			t.Errorf("%s: failed to apply", tt.name)
+		}
+	}
+
+	if got, body := serve(t, h, http.MethodGet, "/readyz"); got != http.StatusOK {
+		t.Errorf("should be ready after leaving maintenance mode: %d, %v", got, body)
+	}
+}
+
+func TestHandlerSlowQueries(t *testing.T) {
+	c := sqlwtest.NewCluster(t)
+	db := sqlw.NewDB(c.Open(t, "master"))
+	db.SetSlowQueryThreshold(time.Nanosecond)
+	h := sqlwhttp.NewHandler(db)
+
+	app := sqlwhttp.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := db.Exec(r.Context(), "DELETE FROM users"); err != nil {
+			t.Fatal(err)
+		}
+	}))
+	app.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodDelete, "/users", nil))
+
+	if got, _ := serve(t, h, http.MethodGet, "/admin/slow-queries"); got != http.StatusForbidden {
+		t.Errorf("slow queries should be disabled without authorizer: %d", got)
+	}
+	h.SetAuthorizer(func(r *http.Request) error {
+		return nil
+	})
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/slow-queries", nil))
+	got := []sqlw.SlowQuery{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].Query != "delete from users" || got[0].Caller != "DELETE /users" {
+		t.Errorf("failed to list slow queries: %v", got)
+	}
+}
+
+func TestHandlerChecksCanceled(t *testing.T) {
+	for _, target := range []string{"/livez", "/readyz"} {
+		target := target
+		t.Run(target, func(t *testing.T) {
+			t.Parallel()
+
+			c := sqlwtest.NewCluster(t)
+			db := sqlw.NewDB(c.Open(t, "master"), c.Open(t, "replica0"))
+			c.Update("master", func(n *sqlwtest.Node) {
+				n.Latency = 10 * time.Second
+			})
+
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+			rec := httptest.NewRecorder()
+			start := time.Now()
+			sqlwhttp.NewHandler(db).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil).WithContext(ctx))
+			if got := time.Since(start); got > time.Second {
+				t.Errorf("should return when the request is done but took: %v", got)
+			}
+			if rec.Code != http.StatusServiceUnavailable {
+				t.Errorf("should be status %d but got: %d", http.StatusServiceUnavailable, rec.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,185 @@
+package sqlwmetrics
+
+import (
+	"expvar"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets are the upper bounds of the buckets of the histograms in seconds.
+var DefaultBuckets = []float64{0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5}
+
+// ExpvarCollector is a Collector that keeps the metrics in memory.
+// The metrics can be published by expvar and served in the Prometheus text format.
+//
+// The histograms are kept as the series of name_bucket, name_sum and name_count.
+type ExpvarCollector struct {
+	mu      sync.Mutex
+	buckets []float64
+	types   map[string]string
+	values  map[string]float64
+}
+
+// NewExpvarCollector returns a new ExpvarCollector with DefaultBuckets.
+func NewExpvarCollector() *ExpvarCollector {
+	return &ExpvarCollector{
+		buckets: DefaultBuckets,
+		types:   map[string]string{},
+		values:  map[string]float64{},
+	}
+}
+
+// SetBuckets sets the upper bounds of the buckets of the histograms in ascending order.
+//
+// This function should be used outside of Goroutine.
+func (c *ExpvarCollector) SetBuckets(buckets ...float64) {
+	c.buckets = buckets
+}
+
+// Add implements Collector.
+func (c *ExpvarCollector) Add(name string, labels Labels, value float64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.types[name] = "counter"
+	c.values[series(name, labels, "")] += value
+}
+
+// Observe implements Collector.
+func (c *ExpvarCollector) Observe(name string, labels Labels, value float64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.types[name] = "histogram"
+	for _, b := range c.buckets {
+		key := series(name+"_bucket", labels, strconv.FormatFloat(b, 'g', -1, 64))
+		if _, ok := c.values[key]; !ok {
+			c.values[key] = 0
+		}
+		if value <= b {
+			c.values[key]++
+		}
+	}
+	c.values[series(name+"_bucket", labels, "+Inf")]++
+	c.values[series(name+"_sum", labels, "")] += value
+	c.values[series(name+"_count", labels, "")]++
+}
+
+// Set implements Collector.
+func (c *ExpvarCollector) Set(name string, labels Labels, value float64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.types[name] = "gauge"
+	c.values[series(name, labels, "")] = value
+}
+
+// Value returns the value of the series, such as `sqlw_queries_total{kind="select",node="replica0"}`.
+func (c *ExpvarCollector) Value(series string) float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.values[series]
+}
+
+// Snapshot returns the values of all the series.
+func (c *ExpvarCollector) Snapshot() map[string]float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	m := map[string]float64{}
+	for k, v := range c.values {
+		m[k] = v
+	}
+	return m
+}
+
+// Publish publishes the snapshot by expvar with the name.
+// It panics if the name is already published like expvar.Publish.
+func (c *ExpvarCollector) Publish(name string) {
+	expvar.Publish(name, expvar.Func(func() interface{} {
+		return c.Snapshot()
+	}))
+}
+
+// ServeHTTP serves the metrics in the Prometheus text format.
+func (c *ExpvarCollector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.mu.Lock()
+	keys := make([]string, 0, len(c.values))
+	for k := range c.values {
+		keys = append(keys, k)
+	}
+	sortSeries(keys)
+	var b strings.Builder
+	typed := map[string]bool{}
+	for _, k := range keys {
+		name := k
+		if i := strings.Index(k, "{"); i >= 0 {
+			name = k[:i]
+		}
+		base := name
+		if _, ok := c.types[name]; !ok {
+			for _, suffix := range []string{"_bucket", "_sum", "_count"} {
+				base = strings.TrimSuffix(base, suffix)
+			}
+		}
+		if !typed[base] {
+			typed[base] = true
+			fmt.Fprintf(&b, "# TYPE %s %s\n", base, c.types[base])
+		}
+		fmt.Fprintf(&b, "%s %s\n", k, strconv.FormatFloat(c.values[k], 'g', -1, 64))
+	}
+	c.mu.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	_, _ = w.Write([]byte(b.String()))
+}
+
+// sortSeries sorts the series by the names and the labels, and the buckets of a histogram by the upper bounds.
+func sortSeries(keys []string) {
+	sort.Slice(keys, func(i, j int) bool {
+		ki, li := splitLe(keys[i])
+		kj, lj := splitLe(keys[j])
+		if ki != kj {
+			return ki < kj
+		}
+		return li < lj
+	})
+}
+
+// splitLe splits the series into the rest and the upper bound of the bucket, which is 0 if the series is not a bucket.
+func splitLe(key string) (string, float64) {
+	i := strings.LastIndex(key, `le="`)
+	if i < 0 {
+		return key, 0
+	}
+	rest := key[i+len(`le="`):]
+	j := strings.Index(rest, `"`)
+	if j < 0 {
+		return key, 0
+	}
+	le, err := strconv.ParseFloat(rest[:j], 64)
+	if err != nil {
+		return key, 0
+	}
+	return key[:i] + rest[j+1:], le
+}
+
+// series returns the name of the series with the labels sorted by the keys.
+func series(name string, labels Labels, le string) string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	list := []string{}
+	for _, k := range keys {
+		list = append(list, fmt.Sprintf("%s=%q", k, labels[k]))
+	}
+	if le != "" {
+		list = append(list, fmt.Sprintf("le=%q", le))
+	}
+	if len(list) == 0 {
+		return name
+	}
+	return name + "{" + strings.Join(list, ",") + "}"
+}
@@ -0,0 +1,54 @@
+package sqlwmetrics_test
+
+import (
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/glassonion1/sqlw/sqlwmetrics"
+)
+
+func TestExpvarCollector(t *testing.T) {
+	c := sqlwmetrics.NewExpvarCollector()
+	c.SetBuckets(0.1, 1, 10)
+	c.Add("requests_total", sqlwmetrics.Labels{"node": "master"}, 1)
+	c.Add("requests_total", sqlwmetrics.Labels{"node": "master"}, 2)
+	c.Set("open", nil, 5)
+	c.Observe("latency_seconds", sqlwmetrics.Labels{"node": "master"}, 0.5)
+	c.Observe("latency_seconds", sqlwmetrics.Labels{"node": "master"}, 2)
+
+	rec := httptest.NewRecorder()
+	c.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	want := `# TYPE latency_seconds histogram
+latency_seconds_bucket{node="master",le="0.1"} 0
+latency_seconds_bucket{node="master",le="1"} 1
+latency_seconds_bucket{node="master",le="10"} 2
+latency_seconds_bucket{node="master",le="+Inf"} 2
+latency_seconds_count{node="master"} 2
+latency_seconds_sum{node="master"} 2.5
+# TYPE open gauge
+open 5
+# TYPE requests_total counter
+requests_total{node="master"} 3
+`
+	if diff := cmp.Diff(rec.Body.String(), want); diff != "" {
+		t.Errorf("failed to serve metrics: %v", diff)
+	}
+
+	// expvar panics if the name is published twice, such as by go test -count=2.
+	name := fmt.Sprintf("sqlw_test_%d", time.Now().UnixNano())
+	c.Publish(name)
+	got := map[string]float64{}
+	if err := json.Unmarshal([]byte(expvar.Get(name).String()), &got); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(got, c.Snapshot()); diff != "" {
+		t.Errorf("failed to publish metrics: %v", diff)
+	}
+}
@@ -0,0 +1,198 @@
+// Package sqlwmetrics provides the metrics of the routing, the transactions and the connection pools of sqlw.DB.
+//
+// The metrics are recorded by Collector, so any metrics library like the Prometheus client can be used through a small adapter.
+// ExpvarCollector is provided as a fallback which needs no other library.
+package sqlwmetrics
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/glassonion1/sqlw"
+)
+
+// The following metrics are recorded.
+const (
+	// Queries is the counter of the queries, executions and preparations by node, role, op and kind.
+	// The digest of the statement is added to the labels if it is enabled by SetDigestLabel.
+	Queries = "sqlw_queries_total"
+	// Retries is the counter of the retried queries, executions and preparations by node, role and op.
+	Retries = "sqlw_retries_total"
+	// Errors is the counter of the failed calls by node, role, op and class.
+	// The hedged reads canceled by sqlw.ErrHedgeCanceled are not counted.
+	Errors = "sqlw_errors_total"
+	// Latency is the histogram of the seconds of the queries, executions and preparations by the same labels as Queries.
+	Latency = "sqlw_query_duration_seconds"
+	// Transactions is the counter of the transactions by node and outcome.
+	Transactions = "sqlw_transactions_total"
+	// Evictions is the counter of the replicas evicted by the health check by node.
+	Evictions = "sqlw_replica_evictions_total"
+	// BreakerOpens is the counter of the circuit breakers opened by node.
+	BreakerOpens = "sqlw_breaker_opens_total"
+	// Failovers is the counter of the failovers by node.
+	Failovers = "sqlw_failovers_total"
+	// Lag is the gauge of the replication lag seconds by node.
+	Lag = "sqlw_replica_lag_seconds"
+	// Healthy is the gauge that is 1 if the node is healthy by node and role.
+	Healthy = "sqlw_node_healthy"
+	// Breaker is the gauge of the state of the circuit breaker by node and role, 0 closed, 1 half-open and 2 open.
+	Breaker = "sqlw_breaker_state"
+	// InFlight is the gauge of the calls in flight by node and role.
+	InFlight = "sqlw_node_in_flight"
+	// The gauges of sql.DBStats by node and role.
+	PoolOpen         = "sqlw_pool_open_connections"
+	PoolInUse        = "sqlw_pool_in_use_connections"
+	PoolIdle         = "sqlw_pool_idle_connections"
+	PoolWaitCount    = "sqlw_pool_wait_count"
+	PoolWaitDuration = "sqlw_pool_wait_duration_seconds"
+)
+
+// The following outcomes of the transactions are recorded.
+const (
+	OutcomeCommit   = "commit"
+	OutcomeRollback = "rollback"
+	// OutcomeRetry is recorded when the transaction is begun again on the new master after failover.
+	OutcomeRetry = "retry"
+)
+
+// Labels are the labels of the metric.
+type Labels map[string]string
+
+// Collector records the metrics.
+type Collector interface {
+	// Add adds the value to the counter.
+	Add(name string, labels Labels, value float64)
+	// Observe records the value to the histogram.
+	Observe(name string, labels Labels, value float64)
+	// Set sets the value to the gauge.
+	Set(name string, labels Labels, value float64)
+}
+
+// Metrics records the metrics of the database to the collector.
+// It is a sqlw.Hook and its HandleEvent is a sqlw.EventHandler.
+type Metrics struct {
+	db          *sqlw.DB
+	collector   Collector
+	digestLabel bool
+}
+
+// Instrument registers Metrics of the database as the hook and the event handler.
+//
+// This function should be used outside of Goroutine.
+func Instrument(db *sqlw.DB, c Collector) *Metrics {
+	m := &Metrics{db: db, collector: c}
+	db.Use(m)
+	db.OnEvent(m.HandleEvent)
+	return m
+}
+
+// SetDigestLabel sets whether the digest of the statement by sqlw.Dialect.Digest is added to the labels of Queries and Latency.
+// The statements that differ only in the values have the same digest, but the number of the series grows with the number of the statements.
+//
+// This function should be used outside of Goroutine.
+func (m *Metrics) SetDigestLabel(enabled bool) {
+	m.digestLabel = enabled
+}
+
+// Before implements sqlw.Hook.
+func (m *Metrics) Before(ctx context.Context, info *sqlw.QueryInfo) (context.Context, error) {
+	return ctx, nil
+}
+
+// After implements sqlw.Hook.
+func (m *Metrics) After(ctx context.Context, info *sqlw.QueryInfo) {
+	node := Labels{"node": info.Node, "role": string(info.Role)}
+	switch info.Op {
+	case sqlw.OpQuery, sqlw.OpExec, sqlw.OpPrepare:
+		labels := Labels{"node": info.Node, "role": string(info.Role), "op": string(info.Op), "kind": sqlw.StatementKind(info.Query)}
+		if m.digestLabel {
+			labels["digest"] = info.Dialect.Digest(info.Query)
+		}
+		m.collector.Add(Queries, labels, 1)
+		m.collector.Observe(Latency, labels, info.Duration.Seconds())
+		if info.Attempt > 1 {
+			m.collector.Add(Retries, Labels{"node": info.Node, "role": string(info.Role), "op": string(info.Op)}, 1)
+		}
+	case sqlw.OpBegin:
+		if info.Attempt > 1 {
+			m.collector.Add(Transactions, Labels{"node": info.Node, "outcome": OutcomeRetry}, 1)
+		}
+	case sqlw.OpTransaction:
+		outcome := OutcomeCommit
+		if info.Err != nil {
+			outcome = OutcomeRollback
+		}
+		m.collector.Add(Transactions, Labels{"node": info.Node, "outcome": outcome}, 1)
+	}
+	if info.Err != nil && info.Op != sqlw.OpTransaction && !errors.Is(info.Err, sqlw.ErrHedgeCanceled) {
+		node["op"] = string(info.Op)
+		node["class"] = string(sqlw.ClassifyError(info.Err))
+		m.collector.Add(Errors, node, 1)
+	}
+}
+
+// HandleEvent records the events of the database.
+func (m *Metrics) HandleEvent(e sqlw.Event) {
+	switch e.Type {
+	case sqlw.EventNodeEvicted:
+		m.collector.Add(Evictions, Labels{"node": e.Node}, 1)
+	case sqlw.EventFailover:
+		m.collector.Add(Failovers, Labels{"node": e.Node}, 1)
+	case sqlw.EventBreakerOpened:
+		m.collector.Add(BreakerOpens, Labels{"node": e.Node}, 1)
+	}
+}
+
+// Collect records the gauges of the nodes, the connection pools and the replication lag.
+// It checks the health of the nodes to get the replication lag.
+func (m *Metrics) Collect(ctx context.Context) {
+	for _, n := range m.db.Nodes() {
+		labels := Labels{"node": n.Name, "role": string(n.Role)}
+		m.collector.Set(Healthy, labels, boolValue(n.Healthy && !n.Drained))
+		m.collector.Set(Breaker, labels, breakerValue(n.Breaker))
+		m.collector.Set(InFlight, labels, float64(n.InFlight))
+		m.collector.Set(PoolOpen, labels, float64(n.Stats.OpenConnections))
+		m.collector.Set(PoolInUse, labels, float64(n.Stats.InUse))
+		m.collector.Set(PoolIdle, labels, float64(n.Stats.Idle))
+		m.collector.Set(PoolWaitCount, labels, float64(n.Stats.WaitCount))
+		m.collector.Set(PoolWaitDuration, labels, n.Stats.WaitDuration.Seconds())
+	}
+	for _, h := range m.db.Health(ctx).Replicas() {
+		if h.Healthy || h.Lag > 0 {
+			m.collector.Set(Lag, Labels{"node": h.Name}, h.Lag.Seconds())
+		}
+	}
+}
+
+// Watch collects the gauges at the interval until the context is done.
+// It is usually called in a new goroutine.
+func (m *Metrics) Watch(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		m.Collect(ctx)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func breakerValue(s sqlw.BreakerState) float64 {
+	switch s {
+	case sqlw.BreakerHalfOpen:
+		return 1
+	case sqlw.BreakerOpen:
+		return 2
+	}
+	return 0
+}
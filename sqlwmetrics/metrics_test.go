@@ -0,0 +1,146 @@
+package sqlwmetrics_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/glassonion1/sqlw"
+	"github.com/glassonion1/sqlw/sqlwmetrics"
+	"github.com/glassonion1/sqlw/sqlwtest"
+)
+
+func TestMetrics(t *testing.T) {
+	c := sqlwtest.NewCluster(t)
+	db := sqlw.NewDB(c.Open(t, "master"), c.Open(t, "replica0"))
+	col := sqlwmetrics.NewExpvarCollector()
+	sqlwmetrics.Instrument(db, col)
+	c.Update("master", func(n *sqlwtest.Node) {
+		n.Errs["DELETE FROM products"] = errors.New("Error 1146: Table 'app.products' doesn't exist")
+	})
+	ctx := context.Background()
+
+	rows, err := db.Query(ctx, "SELECT * FROM users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows.Close()
+	if _, err := db.Exec(ctx, "UPDATE users SET name = ?", "foo"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(ctx, "DELETE FROM products"); err == nil {
+		t.Fatal("should be error")
+	}
+	if err := db.Transaction(ctx, func(ctx context.Context, tx *sqlw.Tx) error {
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Transaction(ctx, func(ctx context.Context, tx *sqlw.Tx) error {
+		return errors.New("error")
+	}); err == nil {
+		t.Fatal("should be error")
+	}
+
+	want := map[string]float64{
+		`sqlw_queries_total{kind="select",node="replica0",op="query",role="replica"}`:                1,
+		`sqlw_queries_total{kind="update",node="master",op="exec",role="master"}`:                    1,
+		`sqlw_queries_total{kind="delete",node="master",op="exec",role="master"}`:                    1,
+		`sqlw_errors_total{class="other",node="master",op="exec",role="master"}`:                     1,
+		`sqlw_transactions_total{node="master",outcome="commit"}`:                                    1,
+		`sqlw_transactions_total{node="master",outcome="rollback"}`:                                  1,
+		`sqlw_query_duration_seconds_count{kind="select",node="replica0",op="query",role="replica"}`: 1,
+	}
+	for k, v := range want {
+		if got := col.Value(k); got != v {
+			t.Errorf("%s should be %v but got: %v", k, v, got)
+		}
+	}
+}
+
+func TestMetricsCollect(t *testing.T) {
+	c := sqlwtest.NewCluster(t)
+	db := sqlw.NewDB(c.Open(t, "master"), c.Open(t, "replica0"), c.Open(t, "replica1"))
+	db.SetMaxReplicaLag(5 * time.Second)
+	col := sqlwmetrics.NewExpvarCollector()
+	m := sqlwmetrics.Instrument(db, col)
+	c.Update("replica0", func(n *sqlwtest.Node) {
+		n.Lag = 3 * time.Second
+	})
+	c.Update("replica1", func(n *sqlwtest.Node) {
+		n.Down = true
+	})
+	ctx := context.Background()
+
+	// Stops watching after the first check
+	wctx, cancel := context.WithCancel(ctx)
+	db.OnEvent(func(e sqlw.Event) {
+		cancel()
+	})
+	if err := db.WatchHealth(wctx, time.Hour); !errors.Is(err, context.Canceled) {
+		t.Fatalf("should be error of %v but got: %v", context.Canceled, err)
+	}
+	m.Collect(ctx)
+
+	want := map[string]float64{
+		`sqlw_replica_lag_seconds{node="replica0"}`:               3,
+		`sqlw_replica_evictions_total{node="replica1"}`:           1,
+		`sqlw_node_healthy{node="replica0",role="replica"}`:       1,
+		`sqlw_node_healthy{node="replica1",role="replica"}`:       0,
+		`sqlw_pool_open_connections{node="master",role="master"}`: 1,
+		`sqlw_breaker_state{node="master",role="master"}`:         0,
+	}
+	got := map[string]float64{}
+	for k := range want {
+		got[k] = col.Value(k)
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("failed to collect: %v", diff)
+	}
+}
+
+func TestMetricsDigestLabel(t *testing.T) {
+	c := sqlwtest.NewCluster(t)
+	db := sqlw.NewDB(c.Open(t, "master"))
+	col := sqlwmetrics.NewExpvarCollector()
+	sqlwmetrics.Instrument(db, col).SetDigestLabel(true)
+	ctx := context.Background()
+
+	for _, q := range []sqlw.SQLMutation{"DELETE FROM users WHERE id IN (1, 2)", "DELETE FROM users WHERE id IN (?)"} {
+		if _, err := db.Exec(ctx, q); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	digest := sqlw.Digest("DELETE FROM users WHERE id IN (?)")
+	key := `sqlw_queries_total{digest="` + digest + `",kind="delete",node="master",op="exec",role="master"}`
+	if got := col.Value(key); got != 2 {
+		t.Errorf("%s should be 2 but got: %v", key, got)
+	}
+}
+
+func TestMetricsHedgedRead(t *testing.T) {
+	c := sqlwtest.NewCluster(t)
+	db := sqlw.NewDB(c.Open(t, "master"), c.Open(t, "replica0"))
+	col := sqlwmetrics.NewExpvarCollector()
+	m := sqlwmetrics.Instrument(db, col)
+	ctx := context.Background()
+
+	m.After(ctx, &sqlw.QueryInfo{Op: sqlw.OpQuery, Query: "SELECT * FROM users", Node: "replica0", Role: sqlw.RoleReplica, Attempt: 1, Err: sqlw.ErrHedgeCanceled})
+	m.After(ctx, &sqlw.QueryInfo{Op: sqlw.OpQuery, Query: "SELECT * FROM users", Node: "replica1", Role: sqlw.RoleReplica, Attempt: 1, Hedged: true})
+
+	want := map[string]float64{
+		`sqlw_queries_total{kind="select",node="replica0",op="query",role="replica"}`:   1,
+		`sqlw_queries_total{kind="select",node="replica1",op="query",role="replica"}`:   1,
+		`sqlw_errors_total{class="canceled",node="replica0",op="query",role="replica"}`: 0,
+		`sqlw_retries_total{node="replica1",op="query",role="replica"}`:                 0,
+	}
+	for k, v := range want {
+		if got := col.Value(k); got != v {
+			t.Errorf("%s should be %v but got: %v", k, v, got)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package sqlwtest
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/glassonion1/sqlw"
+)
+
+// RollbackDB is the handle returned by WithRollback, which executes all the calls in one transaction.
+type RollbackDB struct {
+	tx *sqlw.Tx
+}
+
+var _ sqlw.Executor = (*RollbackDB)(nil)
+
+// WithRollback begins a transaction on the master and returns the handle executing all the calls in it,
+// including the queries for the read replicas. The transaction is rolled back when the test finishes,
+// so that the tests against a shared database are isolated from each other.
+//
+// The transactions of the handle are nested in the transaction with the savepoints,
+// so the functions registered by Tx.AfterCommit are never called.
+// The handle uses one connection, so it should not be used by the parallel calls.
+func WithRollback(t testing.TB, db *sqlw.DB) *RollbackDB {
+	t.Helper()
+	tx, err := db.Begin(context.Background(), nil)
+	if err != nil {
+		t.Fatalf("failed to begin transaction: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := tx.Rollback(context.Background()); err != nil {
+			t.Errorf("failed to rollback: %v", err)
+		}
+	})
+	return &RollbackDB{tx: tx}
+}
+
+// Query executes a query in the transaction.
+func (db *RollbackDB) Query(ctx context.Context, query sqlw.SQLQuery, args ...interface{}) (*sql.Rows, error) {
+	return db.tx.Query(ctx, query, args...)
+}
+
+// QueryForMaster executes a query in the transaction.
+func (db *RollbackDB) QueryForMaster(ctx context.Context, query sqlw.SQLQuery, args ...interface{}) (*sql.Rows, error) {
+	return db.tx.Query(ctx, query, args...)
+}
+
+// QueryRow executes a query that is expected to return at most one row in the transaction.
+func (db *RollbackDB) QueryRow(ctx context.Context, query sqlw.SQLQuery, args ...interface{}) *sql.Row {
+	return db.tx.QueryRow(ctx, query, args...)
+}
+
+// QueryRowForMaster executes a query that is expected to return at most one row in the transaction.
+func (db *RollbackDB) QueryRowForMaster(ctx context.Context, query sqlw.SQLQuery, args ...interface{}) *sql.Row {
+	return db.tx.QueryRow(ctx, query, args...)
+}
+
+// Exec executes a query without returning any rows in the transaction.
+func (db *RollbackDB) Exec(ctx context.Context, query sqlw.SQLMutation, args ...interface{}) (sql.Result, error) {
+	return db.tx.Exec(ctx, query, args...)
+}
+
+// Transaction executes the function in the nested transaction with the savepoint.
+func (db *RollbackDB) Transaction(ctx context.Context, fn sqlw.TxHandlerFunc) error {
+	return db.tx.Transaction(ctx, fn)
+}
+
+// TransactionTx executes the function in the nested transaction with the savepoint.
+// The read only transaction rejects the mutations as DB.TransactionTx, and the other options are ignored
+// because the transaction has already begun.
+func (db *RollbackDB) TransactionTx(ctx context.Context, fn sqlw.TxHandlerFunc, opts *sql.TxOptions) error {
+	return db.tx.TransactionTx(ctx, fn, opts)
+}
@@ -0,0 +1,94 @@
+package sqlwtest_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/glassonion1/sqlw"
+	"github.com/glassonion1/sqlw/sqlwtest"
+)
+
+// createUser is a repository function depending on sqlw.Executor.
+func createUser(ctx context.Context, db sqlw.Executor, name string) (string, error) {
+	err := db.Transaction(ctx, func(ctx context.Context, tx *sqlw.Tx) error {
+		_, err := tx.Exec(ctx, "INSERT INTO users(name) VALUES(?)", name)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	var node string
+	if err := db.QueryRow(ctx, "SELECT node").Scan(&node); err != nil {
+		return "", err
+	}
+	return node, nil
+}
+
+func TestWithRollback(t *testing.T) {
+	c := sqlwtest.NewCluster(t)
+	db := sqlw.NewDB(c.Open(t, "master"), c.Open(t, "replica0"))
+
+	t.Run("isolated", func(t *testing.T) {
+		h := sqlwtest.WithRollback(t, db)
+		ctx := context.Background()
+
+		node, err := createUser(ctx, h, "foo")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if node != "master" {
+			t.Errorf("should read in the transaction on the master but got: %s", node)
+		}
+		rows, err := h.Query(ctx, "SELECT node")
+		if err != nil {
+			t.Fatal(err)
+		}
+		rows.Close()
+		if _, err := h.Exec(ctx, "DELETE FROM users"); err != nil {
+			t.Fatal(err)
+		}
+		errNested := errors.New("nested")
+		err = h.TransactionTx(ctx, func(ctx context.Context, tx *sqlw.Tx) error {
+			return errNested
+		}, nil)
+		if err != errNested {
+			t.Errorf("should be error of %v but got: %v", errNested, err)
+		}
+		// The mutations are rejected in the read only transaction as in production.
+		err = h.TransactionTx(ctx, func(ctx context.Context, tx *sqlw.Tx) error {
+			_, err := tx.Exec(ctx, "DELETE FROM users")
+			return err
+		}, &sql.TxOptions{ReadOnly: true})
+		if !errors.Is(err, sqlw.ErrReadOnlyTx) {
+			t.Errorf("should be error of %v but got: %v", sqlw.ErrReadOnlyTx, err)
+		}
+	})
+
+	want := []string{
+		"BEGIN",
+		"SAVEPOINT sqlw_savepoint_1",
+		"INSERT INTO users(name) VALUES(?)",
+		"RELEASE SAVEPOINT sqlw_savepoint_1",
+		"SELECT node",
+		"SELECT node",
+		"DELETE FROM users",
+		"SAVEPOINT sqlw_savepoint_1",
+		"ROLLBACK TO SAVEPOINT sqlw_savepoint_1",
+		"SAVEPOINT sqlw_savepoint_1",
+		"ROLLBACK TO SAVEPOINT sqlw_savepoint_1",
+		"ROLLBACK",
+	}
+	if diff := cmp.Diff(c.Stmts("master"), want); diff != "" {
+		t.Errorf("failed to execute in the transaction: %v", diff)
+	}
+	if got := c.Stmts("replica0"); len(got) != 0 {
+		t.Errorf("should not execute on the replica: %v", got)
+	}
+	if got := db.Nodes()[0].Stats.InUse; got != 0 {
+		t.Errorf("connection should be returned to the pool: %d", got)
+	}
+}
@@ -0,0 +1,341 @@
+// Package sqlwtest provides a database/sql driver that fakes the nodes of a cluster,
+// so that the routing, the health checks and the transactions of sqlw can be tested without the databases.
+//
+// Each node records the statements it receives, and the results, the errors, the latency,
+// the replication lag and the read only mode of the node can be scripted.
+// The lag and the read only mode are reported to the health checks of both MySQL and PostgreSQL.
+//
+//	c := sqlwtest.NewCluster(t)
+//	db := sqlw.NewDB(c.Open(t, "master"), c.Open(t, "replica0"))
+//	c.Update("replica0", func(n *sqlwtest.Node) {
+//		n.Results["SELECT name FROM users"] = sqlwtest.Result{Columns: []string{"name"}, Rows: [][]driver.Value{{"foo"}}}
+//	})
+//
+// The queries without the scripted results return a row with the column "node", the name of the node.
+//
+// WithRollback isolates the tests against a real database by executing each test in a transaction rolled back at the end.
+package sqlwtest
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// DriverName is the name of the driver registered to database/sql.
+const DriverName = "sqlwtest"
+
+// Driver is a database/sql driver that records the statements each node receives.
+// The DSN is "cluster/node".
+type Driver struct{}
+
+var (
+	clustersMu sync.Mutex
+	clusters   = map[string]*Cluster{}
+	// clusterSeq makes the ids of the clusters unique, even if a test creates more than one.
+	clusterSeq int
+)
+
+func init() {
+	sql.Register(DriverName, Driver{})
+}
+
+// Cluster is a set of fake nodes.
+type Cluster struct {
+	id    string
+	mu    sync.Mutex
+	nodes map[string]*Node
+}
+
+// Node is a fake database server.
+type Node struct {
+	// Stmts is the statements the node received.
+	Stmts []string
+	// Down makes the node return driver.ErrBadConn.
+	Down bool
+	// ReadOnly makes the node reject mutations.
+	ReadOnly bool
+	// Latency delays every statement.
+	Latency time.Duration
+	// Lag is the replication lag the node reports, which is truncated to seconds on MySQL.
+	Lag time.Duration
+	// Errs is the errors returned for the statements.
+	Errs map[string]error
+	// Results is the results returned for the statements.
+	Results map[string]Result
+	// Closed is the prepared statements closed on the node.
+	Closed []string
+}
+
+// Result is the scripted result of the statement.
+type Result struct {
+	// Columns and Rows are returned for the query.
+	Columns []string
+	Rows    [][]driver.Value
+	// RowsAffected is returned for the exec, 1 if it is not set.
+	RowsAffected int64
+	// NoRowsAffected makes the exec return 0 rows affected, such as UPDATE and DELETE matching no rows.
+	NoRowsAffected bool
+}
+
+// NewCluster returns a new cluster that is removed when the test finishes.
+func NewCluster(t testing.TB) *Cluster {
+	t.Helper()
+	c := &Cluster{
+		nodes: map[string]*Node{},
+	}
+	clustersMu.Lock()
+	clusterSeq++
+	c.id = fmt.Sprintf("%s#%d", t.Name(), clusterSeq)
+	clusters[c.id] = c
+	clustersMu.Unlock()
+	t.Cleanup(func() {
+		clustersMu.Lock()
+		delete(clusters, c.id)
+		clustersMu.Unlock()
+	})
+	return c
+}
+
+// Open opens the node of the cluster.
+func (c *Cluster) Open(t testing.TB, name string) *sql.DB {
+	t.Helper()
+	c.node(name)
+	db, err := sql.Open(DriverName, c.id+"/"+name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func (c *Cluster) node(name string) *Node {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n, ok := c.nodes[name]
+	if !ok {
+		n = &Node{Errs: map[string]error{}, Results: map[string]Result{}}
+		c.nodes[name] = n
+	}
+	return n
+}
+
+// Update changes the node under the lock.
+func (c *Cluster) Update(name string, fn func(*Node)) {
+	n := c.node(name)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fn(n)
+}
+
+// Stmts returns the statements the node received.
+func (c *Cluster) Stmts(name string) []string {
+	n := c.node(name)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string{}, n.Stmts...)
+}
+
+// Closed returns the prepared statements closed on the node.
+func (c *Cluster) Closed(name string) []string {
+	n := c.node(name)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string{}, n.Closed...)
+}
+
+// receive records the statement and returns the scripted error.
+func (c *Cluster) receive(ctx context.Context, name, query string) error {
+	n := c.node(name)
+	c.mu.Lock()
+	n.Stmts = append(n.Stmts, query)
+	down, readOnly, latency, err := n.Down, n.ReadOnly, n.Latency, n.Errs[query]
+	c.mu.Unlock()
+
+	if latency > 0 {
+		select {
+		case <-time.After(latency):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	if down {
+		return driver.ErrBadConn
+	}
+	if err != nil {
+		return err
+	}
+	if readOnly && isMutation(query) {
+		return errors.New("Error 1290: The MySQL server is running with the --read-only option so it cannot execute this statement")
+	}
+	return nil
+}
+
+func isMutation(query string) bool {
+	q := strings.ToLower(query)
+	return strings.HasPrefix(q, "insert") || strings.HasPrefix(q, "update") || strings.HasPrefix(q, "delete")
+}
+
+func (Driver) Open(dsn string) (driver.Conn, error) {
+	i := strings.LastIndex(dsn, "/")
+	if i < 0 {
+		return nil, fmt.Errorf("invalid dsn: %s", dsn)
+	}
+	clustersMu.Lock()
+	c, ok := clusters[dsn[:i]]
+	clustersMu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown cluster: %s", dsn[:i])
+	}
+	return &conn{cluster: c, name: dsn[i+1:]}, nil
+}
+
+type conn struct {
+	cluster *Cluster
+	name    string
+}
+
+func (c *conn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+func (c *conn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	if err := c.cluster.receive(ctx, c.name, "PREPARE "+query); err != nil {
+		return nil, err
+	}
+	return &stmt{conn: c, query: query}, nil
+}
+
+func (c *conn) Close() error {
+	return nil
+}
+
+func (c *conn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *conn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	begin := "BEGIN"
+	if opts.ReadOnly {
+		begin = "BEGIN READ ONLY"
+	}
+	if err := c.cluster.receive(ctx, c.name, begin); err != nil {
+		return nil, err
+	}
+	return &tx{conn: c}, nil
+}
+
+func (c *conn) Ping(ctx context.Context) error {
+	return c.cluster.receive(ctx, c.name, "PING")
+}
+
+func (c *conn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	if err := c.cluster.receive(ctx, c.name, query); err != nil {
+		return nil, err
+	}
+	n := c.cluster.node(c.name)
+	c.cluster.mu.Lock()
+	defer c.cluster.mu.Unlock()
+	if r, ok := n.Results[query]; ok {
+		return &rows{columns: r.Columns, values: r.Rows}, nil
+	}
+	// The health checks of MySQL and PostgreSQL.
+	switch query {
+	case "SELECT @@global.read_only":
+		return &rows{columns: []string{"@@global.read_only"}, values: [][]driver.Value{{n.ReadOnly}}}, nil
+	case "SHOW SLAVE STATUS":
+		return &rows{columns: []string{"Seconds_Behind_Master"}, values: [][]driver.Value{{int64(n.Lag / time.Second)}}}, nil
+	case "SHOW REPLICA STATUS":
+		return &rows{columns: []string{"Seconds_Behind_Source"}, values: [][]driver.Value{{int64(n.Lag / time.Second)}}}, nil
+	case "SELECT pg_is_in_recovery()":
+		return &rows{columns: []string{"pg_is_in_recovery"}, values: [][]driver.Value{{n.ReadOnly}}}, nil
+	case "SELECT COALESCE(EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp()), 0) AS lag":
+		return &rows{columns: []string{"lag"}, values: [][]driver.Value{{n.Lag.Seconds()}}}, nil
+	}
+	return &rows{columns: []string{"node"}, values: [][]driver.Value{{c.name}}}, nil
+}
+
+func (c *conn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if err := c.cluster.receive(ctx, c.name, query); err != nil {
+		return nil, err
+	}
+	n := c.cluster.node(c.name)
+	c.cluster.mu.Lock()
+	defer c.cluster.mu.Unlock()
+	if r, ok := n.Results[query]; ok {
+		switch {
+		case r.NoRowsAffected:
+			return driver.RowsAffected(0), nil
+		case r.RowsAffected != 0:
+			return driver.RowsAffected(r.RowsAffected), nil
+		}
+	}
+	return driver.RowsAffected(1), nil
+}
+
+type stmt struct {
+	conn  *conn
+	query string
+}
+
+func (s *stmt) Close() error {
+	n := s.conn.cluster.node(s.conn.name)
+	s.conn.cluster.mu.Lock()
+	defer s.conn.cluster.mu.Unlock()
+	n.Closed = append(n.Closed, s.query)
+	return nil
+}
+
+func (s *stmt) NumInput() int {
+	return -1
+}
+
+func (s *stmt) Exec(args []driver.Value) (driver.Result, error) {
+	return s.conn.ExecContext(context.Background(), s.query, nil)
+}
+
+func (s *stmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.conn.QueryContext(context.Background(), s.query, nil)
+}
+
+type tx struct {
+	conn *conn
+}
+
+func (tx *tx) Commit() error {
+	return tx.conn.cluster.receive(context.Background(), tx.conn.name, "COMMIT")
+}
+
+func (tx *tx) Rollback() error {
+	return tx.conn.cluster.receive(context.Background(), tx.conn.name, "ROLLBACK")
+}
+
+// rows returns the values in the columns.
+type rows struct {
+	columns []string
+	values  [][]driver.Value
+	pos     int
+}
+
+func (r *rows) Columns() []string {
+	return r.columns
+}
+
+func (r *rows) Close() error {
+	return nil
+}
+
+func (r *rows) Next(dest []driver.Value) error {
+	if r.pos >= len(r.values) {
+		return io.EOF
+	}
+	copy(dest, r.values[r.pos])
+	r.pos++
+	return nil
+}
@@ -0,0 +1,201 @@
+package sqlwtest_test
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/glassonion1/sqlw"
+	"github.com/glassonion1/sqlw/sqlwtest"
+)
+
+func TestClusterResults(t *testing.T) {
+	c := sqlwtest.NewCluster(t)
+	db := c.Open(t, "replica0")
+	c.Update("replica0", func(n *sqlwtest.Node) {
+		n.Results["SELECT id, name FROM users"] = sqlwtest.Result{
+			Columns: []string{"id", "name"},
+			Rows:    [][]driver.Value{{int64(1), "foo"}, {int64(2), "bar"}},
+		}
+		n.Results["DELETE FROM users"] = sqlwtest.Result{RowsAffected: 3}
+		n.Results["DELETE FROM sessions"] = sqlwtest.Result{NoRowsAffected: true}
+	})
+	ctx := context.Background()
+
+	rows, err := db.QueryContext(ctx, "SELECT id, name FROM users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	got := []string{}
+	for rows.Next() {
+		var id int64
+		var name string
+		if err := rows.Scan(&id, &name); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, name)
+	}
+	if diff := cmp.Diff(got, []string{"foo", "bar"}); diff != "" {
+		t.Errorf("failed to return the scripted rows: %v", diff)
+	}
+
+	var name string
+	if err := db.QueryRowContext(ctx, "SELECT name FROM users").Scan(&name); err != nil {
+		t.Fatal(err)
+	}
+	if name != "replica0" {
+		t.Errorf("should return the node name but got: %s", name)
+	}
+
+	res, err := db.ExecContext(ctx, "DELETE FROM users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if affected, _ := res.RowsAffected(); affected != 3 {
+		t.Errorf("should be 3 rows affected but got: %d", affected)
+	}
+	res, err = db.ExecContext(ctx, "DELETE FROM sessions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if affected, _ := res.RowsAffected(); affected != 0 {
+		t.Errorf("should be no rows affected but got: %d", affected)
+	}
+
+	want := []string{"SELECT id, name FROM users", "SELECT name FROM users", "DELETE FROM users", "DELETE FROM sessions"}
+	if diff := cmp.Diff(c.Stmts("replica0"), want); diff != "" {
+		t.Errorf("failed to record the statements: %v", diff)
+	}
+}
+
+func TestClusterFailures(t *testing.T) {
+	errScripted := errors.New("scripted")
+	tests := []struct {
+		name   string
+		update func(n *sqlwtest.Node)
+		query  string
+		err    func(err error) bool
+	}{
+		{
+			name:   "down",
+			update: func(n *sqlwtest.Node) { n.Down = true },
+			query:  "SELECT 1",
+			err:    func(err error) bool { return errors.Is(err, driver.ErrBadConn) },
+		},
+		{
+			name:   "scripted error",
+			update: func(n *sqlwtest.Node) { n.Errs["SELECT 1"] = errScripted },
+			query:  "SELECT 1",
+			err:    func(err error) bool { return errors.Is(err, errScripted) },
+		},
+		{
+			name:   "read only",
+			update: func(n *sqlwtest.Node) { n.ReadOnly = true },
+			query:  "UPDATE users SET name = 'foo'",
+			err:    func(err error) bool { return err != nil && strings.HasPrefix(err.Error(), "Error 1290") },
+		},
+		{
+			name:   "latency",
+			update: func(n *sqlwtest.Node) { n.Latency = time.Second },
+			query:  "SELECT 1",
+			err:    func(err error) bool { return errors.Is(err, context.DeadlineExceeded) },
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			c := sqlwtest.NewCluster(t)
+			db := c.Open(t, "master")
+			// Keeps the connection before the node fails.
+			if err := db.Ping(); err != nil {
+				t.Fatal(err)
+			}
+			c.Update("master", tt.update)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+			_, err := db.ExecContext(ctx, tt.query)
+			if !tt.err(err) {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestClusterLag(t *testing.T) {
+	c := sqlwtest.NewCluster(t)
+	db := c.Open(t, "replica0")
+	c.Update("replica0", func(n *sqlwtest.Node) {
+		n.Lag = 5 * time.Second
+		n.ReadOnly = true
+	})
+
+	var lag int64
+	if err := db.QueryRow("SHOW SLAVE STATUS").Scan(&lag); err != nil {
+		t.Fatal(err)
+	}
+	if lag != 5 {
+		t.Errorf("should be 5 seconds behind but got: %d", lag)
+	}
+	var readOnly bool
+	if err := db.QueryRow("SELECT @@global.read_only").Scan(&readOnly); err != nil {
+		t.Fatal(err)
+	}
+	if !readOnly {
+		t.Error("should be read only")
+	}
+}
+
+func TestClusterPostgresHealth(t *testing.T) {
+	c := sqlwtest.NewCluster(t)
+	db := sqlw.NewDB(c.Open(t, "master"), c.Open(t, "replica0"))
+	db.SetDialect(sqlw.DialectPostgres)
+	db.SetMaxReplicaLag(time.Second)
+	c.Update("master", func(n *sqlwtest.Node) {
+		n.ReadOnly = true
+	})
+	c.Update("replica0", func(n *sqlwtest.Node) {
+		n.Lag = 1500 * time.Millisecond
+	})
+
+	r := db.Health(context.Background())
+	if m := r.Master(); !m.Healthy || !m.ReadOnly {
+		t.Errorf("the master should be read only: %+v", m)
+	}
+	if rep := r.Replicas()[0]; rep.Healthy || rep.Lag != 1500*time.Millisecond {
+		t.Errorf("the replica should lag behind the limit: %+v", rep)
+	}
+	want := []string{"PING", "SELECT COALESCE(EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp()), 0) AS lag"}
+	if diff := cmp.Diff(c.Stmts("replica0"), want); diff != "" {
+		t.Errorf("should check the lag of PostgreSQL: %v", diff)
+	}
+}
+
+func TestNewClusterTwice(t *testing.T) {
+	c0 := sqlwtest.NewCluster(t)
+	c1 := sqlwtest.NewCluster(t)
+	db0 := c0.Open(t, "master")
+	db1 := c1.Open(t, "master")
+
+	if _, err := db0.Exec("DELETE FROM a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db1.Exec("DELETE FROM b"); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(c0.Stmts("master"), []string{"DELETE FROM a"}); diff != "" {
+		t.Errorf("failed to record the statements on the first cluster: %v", diff)
+	}
+	if diff := cmp.Diff(c1.Stmts("master"), []string{"DELETE FROM b"}); diff != "" {
+		t.Errorf("failed to record the statements on the second cluster: %v", diff)
+	}
+}
@@ -0,0 +1,168 @@
+// Package sqlwtrace provides the hook that traces the calls on sqlw.DB.
+//
+// A span is created for each query, execution and preparation, and a parent span is created for each transaction.
+// The spans are created by Tracer, so any tracing library like OpenTelemetry can be used through a small adapter.
+package sqlwtrace
+
+import (
+	"context"
+	"strconv"
+	"sync"
+
+	"github.com/glassonion1/sqlw"
+)
+
+// The following attributes are set to the spans.
+const (
+	AttrSystem    = "db.system"
+	AttrStatement = "db.statement"
+	AttrOperation = "db.operation"
+	AttrNode      = "db.sqlw.node"
+	AttrRole      = "db.sqlw.role"
+	AttrInTx      = "db.sqlw.in_tx"
+	AttrAttempt   = "db.sqlw.attempt"
+	AttrHedged    = "db.sqlw.hedged"
+	AttrCaller    = "db.sqlw.caller"
+	AttrRows      = "db.sqlw.rows_affected"
+)
+
+// Tracer starts the spans.
+type Tracer interface {
+	// Start starts a new span as a child of the span in the context,
+	// and returns the context with the new span.
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+// Span is a span started by Tracer.
+type Span interface {
+	SetAttribute(key string, value interface{})
+	RecordError(err error)
+	End()
+}
+
+type spanKey struct{}
+
+// Hook is a sqlw.Hook that traces the calls.
+type Hook struct {
+	tracer Tracer
+}
+
+// NewHook returns a new Hook that starts the spans with the tracer.
+func NewHook(tracer Tracer) *Hook {
+	return &Hook{tracer: tracer}
+}
+
+// Before implements sqlw.Hook.
+func (h *Hook) Before(ctx context.Context, info *sqlw.QueryInfo) (context.Context, error) {
+	ctx, span := h.tracer.Start(ctx, "sqlw."+string(info.Op))
+	span.SetAttribute(AttrSystem, string(info.Dialect))
+	span.SetAttribute(AttrOperation, string(info.Op))
+	if info.Query != "" {
+		span.SetAttribute(AttrStatement, info.Dialect.Sanitize(info.Query))
+	}
+	span.SetAttribute(AttrNode, info.Node)
+	span.SetAttribute(AttrRole, string(info.Role))
+	span.SetAttribute(AttrInTx, info.InTx)
+	span.SetAttribute(AttrAttempt, info.Attempt)
+	if info.Hedged {
+		span.SetAttribute(AttrHedged, true)
+	}
+	if info.Caller != "" {
+		span.SetAttribute(AttrCaller, info.Caller)
+	}
+	return context.WithValue(ctx, spanKey{}, span), nil
+}
+
+// After implements sqlw.Hook.
+func (h *Hook) After(ctx context.Context, info *sqlw.QueryInfo) {
+	span, ok := ctx.Value(spanKey{}).(Span)
+	if !ok {
+		return
+	}
+	if info.RowsAffected >= 0 {
+		span.SetAttribute(AttrRows, info.RowsAffected)
+	}
+	if info.Err != nil {
+		span.RecordError(info.Err)
+	}
+	span.End()
+}
+
+// RecordedSpan is a span recorded by Recorder.
+type RecordedSpan struct {
+	ID   string
+	Name string
+	// ParentID is the ID of the parent span, it is empty for the root span.
+	ParentID   string
+	Attributes map[string]interface{}
+	Err        error
+	Ended      bool
+}
+
+// Recorder is a Tracer that keeps the spans in memory, it is useful for testing.
+type Recorder struct {
+	mu    sync.Mutex
+	spans []*RecordedSpan
+}
+
+// NewRecorder returns a new Recorder.
+func NewRecorder() *Recorder {
+	return &Recorder{}
+}
+
+// Start implements Tracer.
+func (r *Recorder) Start(ctx context.Context, name string) (context.Context, Span) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s := &RecordedSpan{
+		ID:         strconv.Itoa(len(r.spans) + 1),
+		Name:       name,
+		Attributes: map[string]interface{}{},
+	}
+	if parent, ok := ctx.Value(recorderKey{}).(*RecordedSpan); ok {
+		s.ParentID = parent.ID
+	}
+	r.spans = append(r.spans, s)
+	return context.WithValue(ctx, recorderKey{}, s), &recordingSpan{recorder: r, span: s}
+}
+
+// Spans returns copies of the recorded spans in the started order.
+func (r *Recorder) Spans() []RecordedSpan {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	list := []RecordedSpan{}
+	for _, s := range r.spans {
+		c := *s
+		c.Attributes = map[string]interface{}{}
+		for k, v := range s.Attributes {
+			c.Attributes[k] = v
+		}
+		list = append(list, c)
+	}
+	return list
+}
+
+type recorderKey struct{}
+
+type recordingSpan struct {
+	recorder *Recorder
+	span     *RecordedSpan
+}
+
+func (s *recordingSpan) SetAttribute(key string, value interface{}) {
+	s.recorder.mu.Lock()
+	defer s.recorder.mu.Unlock()
+	s.span.Attributes[key] = value
+}
+
+func (s *recordingSpan) RecordError(err error) {
+	s.recorder.mu.Lock()
+	defer s.recorder.mu.Unlock()
+	s.span.Err = err
+}
+
+func (s *recordingSpan) End() {
+	s.recorder.mu.Lock()
+	defer s.recorder.mu.Unlock()
+	s.span.Ended = true
+}
@@ -0,0 +1,105 @@
+package sqlwtrace_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/glassonion1/sqlw"
+	"github.com/glassonion1/sqlw/sqlwtest"
+	"github.com/glassonion1/sqlw/sqlwtrace"
+)
+
+// tree returns "name(parent name)" of each span.
+func tree(spans []sqlwtrace.RecordedSpan) []string {
+	names := map[string]string{}
+	list := []string{}
+	for _, s := range spans {
+		names[s.ID] = s.Name
+		list = append(list, s.Name+"("+names[s.ParentID]+")")
+	}
+	return list
+}
+
+func TestHook(t *testing.T) {
+	c := sqlwtest.NewCluster(t)
+	db := sqlw.NewDB(c.Open(t, "master"), c.Open(t, "replica0"))
+	r := sqlwtrace.NewRecorder()
+	db.Use(sqlwtrace.NewHook(r))
+
+	ctx, root := r.Start(context.Background(), "GET /users")
+	rows, err := db.Query(ctx, "SELECT * FROM users WHERE name = 'foo'  AND age > 20")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows.Close()
+	err = db.Transaction(ctx, func(ctx context.Context, tx *sqlw.Tx) error {
+		_, err := tx.Exec(ctx, "UPDATE users SET name = ?", "bar")
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	root.End()
+
+	spans := r.Spans()
+	want := []string{
+		"GET /users()",
+		"sqlw.query(GET /users)",
+		"sqlw.transaction(GET /users)",
+		"sqlw.begin(sqlw.transaction)",
+		"sqlw.exec(sqlw.transaction)",
+		"sqlw.commit(sqlw.transaction)",
+	}
+	if diff := cmp.Diff(tree(spans), want); diff != "" {
+		t.Errorf("failed to trace: %v", diff)
+	}
+	for _, s := range spans {
+		if !s.Ended {
+			t.Errorf("span should be ended: %v", s.Name)
+		}
+	}
+
+	wantAttrs := map[string]interface{}{
+		sqlwtrace.AttrSystem:    "mysql",
+		sqlwtrace.AttrOperation: "query",
+		sqlwtrace.AttrStatement: "select * from users where name = ? and age > ?",
+		sqlwtrace.AttrNode:      "replica0",
+		sqlwtrace.AttrRole:      "replica",
+		sqlwtrace.AttrInTx:      false,
+		sqlwtrace.AttrAttempt:   1,
+	}
+	if diff := cmp.Diff(spans[1].Attributes, wantAttrs); diff != "" {
+		t.Errorf("failed to set attributes: %v", diff)
+	}
+	if got := spans[4].Attributes[sqlwtrace.AttrRows]; got != int64(1) {
+		t.Errorf("should be 1 row affected but got: %v", got)
+	}
+}
+
+func TestHookRetry(t *testing.T) {
+	c := sqlwtest.NewCluster(t)
+	db := sqlw.NewDB(c.Open(t, "master"))
+	if err := db.AddMasterCandidate("standby", c.Open(t, "standby")); err != nil {
+		t.Fatal(err)
+	}
+	c.Update("master", func(n *sqlwtest.Node) {
+		n.ReadOnly = true
+	})
+	r := sqlwtrace.NewRecorder()
+	db.Use(sqlwtrace.NewHook(r))
+
+	if _, err := db.Exec(context.Background(), "DELETE FROM users"); err != nil {
+		t.Fatal(err)
+	}
+
+	got := []interface{}{}
+	for _, s := range r.Spans() {
+		got = append(got, s.Attributes[sqlwtrace.AttrNode], s.Attributes[sqlwtrace.AttrAttempt], s.Err != nil)
+	}
+	want := []interface{}{"master", 1, true, "standby", 2, false}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("failed to trace retries: %v", diff)
+	}
+}
@@ -0,0 +1,174 @@
+package sqlw
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// The following errors are returned by Stmt.
+var (
+	ErrStmtClosed = errors.New("statement is closed")
+	ErrArgCount   = errors.New("wrong number of args")
+)
+
+// Stmt is a prepared statement for the queries on the read replicas, returned by DB.PrepareQuery.
+//
+// Unlike *sql.Stmt, it is not bound to a replica. The executions are balanced across the healthy replicas
+// as DB.Query, and the statement is prepared on each replica on the first execution there.
+// The statements on the removed replicas are released when the statement is prepared on another replica.
+// It is safe for concurrent use.
+type Stmt struct {
+	db    *DB
+	query string
+	// numArgs is the number of the placeholders, -1 if it is unknown.
+	numArgs int
+	mu      sync.Mutex
+	stmts   map[*node]*sql.Stmt
+	closed  bool
+}
+
+// PrepareQuery creates a prepared statement for later queries.The caller must call the statement's Close method when the statement is no longer needed.
+// This method is executed on the read replica and can use for SELECT statements only.
+//
+// The statement is prepared on a replica at once to report the error of the statement,
+// and on the other replicas when the queries are executed on them.
+func (db *DB) PrepareQuery(ctx context.Context, query SQLQuery) (*Stmt, error) {
+	if err := query.Validate(); err != nil {
+		return nil, err
+	}
+	s := &Stmt{
+		db:      db,
+		query:   query.String(),
+		numArgs: placeholderCount(query.String()),
+		stmts:   map[*node]*sql.Stmt{},
+	}
+	if _, err := s.on(ctx, db.getReplica()); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Query executes the prepared query statement with the given arguments and returns the query results as a *sql.Rows.
+// It returns ErrArgCount without sending the query if the number of the args differs from the placeholders.
+func (s *Stmt) Query(ctx context.Context, args ...interface{}) (*sql.Rows, error) {
+	if err := s.validate(args); err != nil {
+		return nil, err
+	}
+	v, err := s.db.withReplica(ctx, s.query, func(ctx context.Context, n *node) (interface{}, error) {
+		stmt, err := s.on(ctx, n)
+		if err != nil {
+			return nil, err
+		}
+		var rows *sql.Rows
+		info := &QueryInfo{Op: OpQuery, Query: s.query, Args: args}
+		err = s.db.run(ctx, info, n, func(ctx context.Context) error {
+			var err error
+			rows, err = stmt.QueryContext(ctx, args...)
+			return err
+		})
+		return rows, err
+	})
+	rows, _ := v.(*sql.Rows)
+	return rows, err
+}
+
+// QueryRow executes the prepared query statement with the given arguments. QueryRow always returns a non-nil value.
+// Errors are deferred until Row's Scan method is called, including ErrArgCount and ErrStmtClosed.
+func (s *Stmt) QueryRow(ctx context.Context, args ...interface{}) *sql.Row {
+	if err := s.validate(args); err != nil {
+		return errRow(err)
+	}
+	v, err := s.db.withReplica(ctx, s.query, func(ctx context.Context, n *node) (interface{}, error) {
+		stmt, err := s.on(ctx, n)
+		if err != nil {
+			return nil, err
+		}
+		var row *sql.Row
+		info := &QueryInfo{Op: OpQuery, Query: s.query, Args: args}
+		err = s.db.run(ctx, info, n, func(ctx context.Context) error {
+			row = stmt.QueryRowContext(ctx, args...)
+			return row.Err()
+		})
+		if row == nil {
+			return nil, err
+		}
+		return row, row.Err()
+	})
+	if row, ok := v.(*sql.Row); ok {
+		return row
+	}
+	return errRow(err)
+}
+
+// Close closes the statements prepared on the replicas.
+func (s *Stmt) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	var err error
+	for n, stmt := range s.stmts {
+		if cerr := stmt.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+		delete(s.stmts, n)
+	}
+	return err
+}
+
+func (s *Stmt) validate(args []interface{}) error {
+	if s.numArgs >= 0 && len(args) != s.numArgs {
+		return fmt.Errorf("%w: the statement takes %d but got %d", ErrArgCount, s.numArgs, len(args))
+	}
+	return nil
+}
+
+// on returns the statement prepared on the node, preparing it if it is not yet.
+func (s *Stmt) on(ctx context.Context, n *node) (*sql.Stmt, error) {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil, ErrStmtClosed
+	}
+	if stmt, ok := s.stmts[n]; ok {
+		s.mu.Unlock()
+		return stmt, nil
+	}
+	s.mu.Unlock()
+
+	stmt, err := s.db.prepare(ctx, n, s.query)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		stmt.Close()
+		return nil, ErrStmtClosed
+	}
+	if prepared, ok := s.stmts[n]; ok {
+		// Another execution prepared it in the meantime.
+		stmt.Close()
+		return prepared, nil
+	}
+	s.prune()
+	s.stmts[n] = stmt
+	return stmt, nil
+}
+
+// prune closes the statements on the nodes removed from the database.
+// The caller must hold s.mu.
+func (s *Stmt) prune() {
+	for n, stmt := range s.stmts {
+		if !s.db.contains(n) {
+			stmt.Close()
+			delete(s.stmts, n)
+		}
+	}
+}
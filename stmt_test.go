@@ -0,0 +1,161 @@
+package sqlw_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/glassonion1/sqlw"
+	"github.com/glassonion1/sqlw/sqlwtest"
+)
+
+func TestDBPrepareQuery(t *testing.T) {
+	c := sqlwtest.NewCluster(t)
+	db := sqlw.NewDB(c.Open(t, "master"), c.Open(t, "replica0"), c.Open(t, "replica1"))
+	ctx := context.Background()
+
+	// Only replica0 is selected at first.
+	if err := db.SetDrained("replica1", true); err != nil {
+		t.Fatal(err)
+	}
+	stmt, err := db.PrepareQuery(ctx, "SELECT node WHERE id = ?")
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := func() string {
+		t.Helper()
+		var name string
+		if err := stmt.QueryRow(ctx, 1).Scan(&name); err != nil {
+			t.Fatal(err)
+		}
+		return name
+	}
+	if got := query(); got != "replica0" {
+		t.Errorf("should be executed on replica0 but got: %s", got)
+	}
+
+	// The statement follows the selection of the replicas.
+	if err := db.SetDrained("replica0", true); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SetDrained("replica1", false); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if got := query(); got != "replica1" {
+			t.Errorf("should be executed on replica1 but got: %s", got)
+		}
+	}
+	rows, err := stmt.Query(ctx, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows.Close()
+
+	if diff := cmp.Diff(c.Stmts("replica0"), []string{"PREPARE SELECT node WHERE id = ?", "SELECT node WHERE id = ?"}); diff != "" {
+		t.Errorf("failed to execute on replica0: %v", diff)
+	}
+	want := []string{"PREPARE SELECT node WHERE id = ?", "SELECT node WHERE id = ?", "SELECT node WHERE id = ?", "SELECT node WHERE id = ?"}
+	if diff := cmp.Diff(c.Stmts("replica1"), want); diff != "" {
+		t.Errorf("failed to execute on replica1: %v", diff)
+	}
+
+	if err := stmt.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"replica0", "replica1"} {
+		if diff := cmp.Diff(c.Closed(name), []string{"SELECT node WHERE id = ?"}); diff != "" {
+			t.Errorf("failed to close the statement on %s: %v", name, diff)
+		}
+	}
+	if _, err := stmt.Query(ctx, 1); !errors.Is(err, sqlw.ErrStmtClosed) {
+		t.Errorf("should be error of %v but got: %v", sqlw.ErrStmtClosed, err)
+	}
+	var name string
+	if err := stmt.QueryRow(ctx, 1).Scan(&name); !errors.Is(err, sqlw.ErrStmtClosed) {
+		t.Errorf("should be error of %v but got: %v", sqlw.ErrStmtClosed, err)
+	}
+}
+
+func TestStmtArgCount(t *testing.T) {
+	tests := []struct {
+		name  string
+		query sqlw.SQLQuery
+		args  []interface{}
+		err   error
+	}{
+		{
+			name:  "question marks",
+			query: "SELECT node WHERE id = ? AND name IN (?, ?)",
+			args:  []interface{}{1, "a", "b"},
+		},
+		{
+			name:  "too few args",
+			query: "SELECT node WHERE id = ? AND name = ?",
+			args:  []interface{}{1},
+			err:   sqlw.ErrArgCount,
+		},
+		{
+			name:  "too many args",
+			query: "SELECT node WHERE id = ?",
+			args:  []interface{}{1, 2},
+			err:   sqlw.ErrArgCount,
+		},
+		{
+			name:  "question mark in literal",
+			query: "SELECT node WHERE name = '?'",
+			args:  []interface{}{1},
+			err:   sqlw.ErrArgCount,
+		},
+		{
+			name:  "numbered placeholders",
+			query: "SELECT node WHERE a = $1 OR b = $2 OR c = $1",
+			args:  []interface{}{1, 2},
+		},
+		{
+			name:  "numbered placeholders with too few args",
+			query: "SELECT node WHERE a = $1 OR b = $2 OR c = $1",
+			args:  []interface{}{1},
+			err:   sqlw.ErrArgCount,
+		},
+		{
+			name:  "named placeholders are not validated",
+			query: "SELECT node WHERE a = :a",
+			args:  []interface{}{1, 2},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			c := sqlwtest.NewCluster(t)
+			db := sqlw.NewDB(c.Open(t, "master"), c.Open(t, "replica0"))
+			ctx := context.Background()
+			stmt, err := db.PrepareQuery(ctx, tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer stmt.Close()
+
+			rows, err := stmt.Query(ctx, tt.args...)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("should be error of %v but got: %v", tt.err, err)
+			}
+			if err == nil {
+				rows.Close()
+				return
+			}
+			if got := c.Stmts("replica0"); len(got) != 1 {
+				t.Errorf("should not send the query: %v", got)
+			}
+			var name string
+			if err := stmt.QueryRow(ctx, tt.args...).Scan(&name); !errors.Is(err, tt.err) {
+				t.Errorf("should be error of %v but got: %v", tt.err, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,165 @@
+package sqlw
+
+import (
+	"container/list"
+	"context"
+	"database/sql"
+	"sync"
+	"sync/atomic"
+)
+
+// SetStmtCacheSize sets the max number of the prepared statements cached on each node. 0 disables the cache, which is the default.
+//
+// When the cache is enabled, Query, QueryRow and Exec prepare the statement on the node on the first use,
+// and reuse it for the later calls of the same statement. The least recently used statements are closed over the size,
+// and the statements of the removed nodes are closed when the nodes are drained.
+// The statements with the SQL comment bypass the cache, because the comment differs for each call.
+func (db *DB) SetStmtCacheSize(size int) {
+	atomic.StoreInt32(&db.stmtCacheSize, int32(size))
+	master, replicas := db.nodes()
+	for _, n := range append([]*node{master}, replicas...) {
+		n.stmts.trim(size)
+	}
+}
+
+// stmtCache is the LRU cache of the prepared statements on the node.
+type stmtCache struct {
+	mu sync.Mutex
+	// lru is the list of *cachedStmt, the most recently used first.
+	lru   *list.List
+	items map[string]*list.Element
+}
+
+// cachedStmt is the prepared statement in the cache.
+// It is closed after it is evicted and all the calls using it return.
+type cachedStmt struct {
+	query   string
+	stmt    *sql.Stmt
+	refs    int
+	evicted bool
+}
+
+// get returns the statement of the query, preparing it on the database if it is not cached.
+// The caller must call put after using the statement.
+func (c *stmtCache) get(ctx context.Context, db *sql.DB, query string, size int) (*cachedStmt, error) {
+	c.mu.Lock()
+	if e, ok := c.items[query]; ok {
+		c.lru.MoveToFront(e)
+		s := e.Value.(*cachedStmt)
+		s.refs++
+		c.mu.Unlock()
+		return s, nil
+	}
+	c.mu.Unlock()
+
+	stmt, err := db.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.items[query]; ok {
+		// Another call prepared it in the meantime.
+		stmt.Close()
+		c.lru.MoveToFront(e)
+		s := e.Value.(*cachedStmt)
+		s.refs++
+		return s, nil
+	}
+	if c.items == nil {
+		c.lru = list.New()
+		c.items = map[string]*list.Element{}
+	}
+	s := &cachedStmt{query: query, stmt: stmt, refs: 1}
+	c.items[query] = c.lru.PushFront(s)
+	c.evict(size)
+	return s, nil
+}
+
+// put releases the statement returned by get.
+func (c *stmtCache) put(s *cachedStmt) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s.refs--
+	if s.evicted && s.refs == 0 {
+		s.stmt.Close()
+	}
+}
+
+// trim evicts the statements over the size.
+func (c *stmtCache) trim(size int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.evict(size)
+}
+
+// evict evicts the least recently used statements over the size.
+func (c *stmtCache) evict(size int) {
+	if c.lru == nil {
+		return
+	}
+	for c.lru.Len() > size {
+		e := c.lru.Back()
+		s := e.Value.(*cachedStmt)
+		c.lru.Remove(e)
+		delete(c.items, s.query)
+		s.evicted = true
+		if s.refs == 0 {
+			s.stmt.Close()
+		}
+	}
+}
+
+// len returns the number of the cached statements.
+func (c *stmtCache) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.lru == nil {
+		return 0
+	}
+	return c.lru.Len()
+}
+
+// execer is the database or the cached statement on the node that executes the statement.
+type execer struct {
+	db    *sql.DB
+	stmt  *sql.Stmt
+	query string
+}
+
+func (e execer) QueryContext(ctx context.Context, args ...interface{}) (*sql.Rows, error) {
+	if e.stmt != nil {
+		return e.stmt.QueryContext(ctx, args...)
+	}
+	return e.db.QueryContext(ctx, e.query, args...)
+}
+
+func (e execer) QueryRowContext(ctx context.Context, args ...interface{}) *sql.Row {
+	if e.stmt != nil {
+		return e.stmt.QueryRowContext(ctx, args...)
+	}
+	return e.db.QueryRowContext(ctx, e.query, args...)
+}
+
+func (e execer) ExecContext(ctx context.Context, args ...interface{}) (sql.Result, error) {
+	if e.stmt != nil {
+		return e.stmt.ExecContext(ctx, args...)
+	}
+	return e.db.ExecContext(ctx, e.query, args...)
+}
+
+// execerOn returns the execer of the query on the node, and the function to be called after the execution.
+// It uses the cached statement if the cache is enabled and the query has no SQL comment.
+func (db *DB) execerOn(ctx context.Context, n *node, query string) (execer, func(), error) {
+	commented := db.comment(ctx, query)
+	size := int(atomic.LoadInt32(&db.stmtCacheSize))
+	if size <= 0 || commented != query {
+		return execer{db: n.db, query: commented}, func() {}, nil
+	}
+	s, err := n.stmts.get(ctx, n.db, query, size)
+	if err != nil {
+		return execer{}, nil, err
+	}
+	return execer{stmt: s.stmt}, func() { n.stmts.put(s) }, nil
+}
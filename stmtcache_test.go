@@ -0,0 +1,120 @@
+package sqlw_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/glassonion1/sqlw"
+	"github.com/glassonion1/sqlw/sqlwtest"
+)
+
+func TestDBSetStmtCacheSize(t *testing.T) {
+	c := sqlwtest.NewCluster(t)
+	db := sqlw.NewDB(c.Open(t, "master"))
+	db.SetStmtCacheSize(2)
+	ctx := context.Background()
+
+	for _, q := range []sqlw.SQLMutation{"DELETE FROM a", "DELETE FROM a", "DELETE FROM b", "DELETE FROM c", "DELETE FROM a"} {
+		if _, err := db.Exec(ctx, q); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := []string{
+		"PREPARE DELETE FROM a", "DELETE FROM a", "DELETE FROM a",
+		"PREPARE DELETE FROM b", "DELETE FROM b",
+		// Evicts DELETE FROM a
+		"PREPARE DELETE FROM c", "DELETE FROM c",
+		// Evicts DELETE FROM b
+		"PREPARE DELETE FROM a", "DELETE FROM a",
+	}
+	if diff := cmp.Diff(c.Stmts("master"), want); diff != "" {
+		t.Errorf("failed to cache statements: %v", diff)
+	}
+	if diff := cmp.Diff(c.Closed("master"), []string{"DELETE FROM a", "DELETE FROM b"}); diff != "" {
+		t.Errorf("failed to close evicted statements: %v", diff)
+	}
+	if got := db.Nodes()[0].Stmts; got != 2 {
+		t.Errorf("should cache 2 statements but got: %d", got)
+	}
+
+	// Shrinks and disables the cache.
+	db.SetStmtCacheSize(0)
+	if got := db.Nodes()[0].Stmts; got != 0 {
+		t.Errorf("should cache no statements but got: %d", got)
+	}
+	if _, err := db.Exec(ctx, "DELETE FROM a"); err != nil {
+		t.Fatal(err)
+	}
+	if got := c.Stmts("master"); got[len(got)-1] != "DELETE FROM a" || got[len(got)-2] != "DELETE FROM a" {
+		t.Errorf("should not prepare the statement: %v", got)
+	}
+}
+
+func TestDBSetStmtCacheSizeQuery(t *testing.T) {
+	c := sqlwtest.NewCluster(t)
+	db := sqlw.NewDB(c.Open(t, "master"), c.Open(t, "replica0"))
+	db.SetStmtCacheSize(10)
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var name string
+			if err := db.QueryRow(ctx, "SELECT node").Scan(&name); err != nil {
+				t.Error(err)
+			}
+			rows, err := db.Query(ctx, "SELECT node")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			rows.Close()
+		}()
+	}
+	wg.Wait()
+
+	prepared := 0
+	for _, s := range c.Stmts("replica0") {
+		if s == "PREPARE SELECT node" {
+			prepared++
+		}
+	}
+	// The concurrent calls may prepare the statement at the same time, but only one is cached.
+	if prepared == 0 || len(c.Closed("replica0")) != prepared-1 {
+		t.Errorf("failed to cache statement: prepared %d, closed %v", prepared, c.Closed("replica0"))
+	}
+
+	// The statements are closed with the removed node.
+	if err := db.RemoveReplica(ctx, "replica0"); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(c.Closed("replica0")); got != prepared {
+		t.Errorf("should close all the statements but got: %d", got)
+	}
+}
+
+func TestDBSetStmtCacheSizeComment(t *testing.T) {
+	c := sqlwtest.NewCluster(t)
+	db := sqlw.NewDB(c.Open(t, "master"))
+	db.SetStmtCacheSize(10)
+	db.SetSQLComment(true)
+	ctx := sqlw.WithCommentTag(context.Background(), "route", "a")
+
+	if _, err := db.Exec(ctx, "DELETE FROM a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(context.Background(), "DELETE FROM a"); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"DELETE FROM a /*route='a'*/", "PREPARE DELETE FROM a", "DELETE FROM a"}
+	if diff := cmp.Diff(c.Stmts("master"), want); diff != "" {
+		t.Errorf("commented statements should bypass the cache: %v", diff)
+	}
+}
@@ -59,7 +59,7 @@ func TestDBPrepareQuery(t *testing.T) {
 				t.Error(err)
 			}
 			defer stmt.Close()
-			rows, err := stmt.Query(tt.arg)
+			rows, err := stmt.Query(context.Background(), tt.arg)
 			if err != nil {
 				t.Error(err)
 			}
@@ -125,7 +125,7 @@ func TestDBPrepareQueryForMaster(t *testing.T) {
 				t.Error(err)
 			}
 			defer stmt.Close()
-			rows, err := stmt.Query(tt.arg)
+			rows, err := stmt.Query(context.Background(), tt.arg)
 			if err != nil {
 				t.Error(err)
 			}
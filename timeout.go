@@ -0,0 +1,100 @@
+package sqlw
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// TimeoutError is returned when the call on the node exceeds the deadline.
+type TimeoutError struct {
+	Op Op
+	// Node is the name of the node that timed out.
+	Node string
+	// Timeout is the timeout applied by sqlw, 0 if the deadline is set by the caller.
+	Timeout time.Duration
+	Err     error
+}
+
+func (e *TimeoutError) Error() string {
+	if e.Timeout > 0 {
+		return fmt.Sprintf("%s on %s timed out after %v: %v", e.Op, e.Node, e.Timeout, e.Err)
+	}
+	return fmt.Sprintf("%s on %s timed out: %v", e.Op, e.Node, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *TimeoutError) Unwrap() error {
+	return e.Err
+}
+
+type timeoutKey struct{}
+
+// WithQueryTimeout returns a new context with the timeout of the calls, which overrides the default timeouts.
+// The deadline of the context is kept if it is earlier than the timeout, and 0 disables the default timeouts.
+func WithQueryTimeout(ctx context.Context, d time.Duration) context.Context {
+	return context.WithValue(ctx, timeoutKey{}, d)
+}
+
+// SetDefaultTimeouts sets the timeouts of the reads, the writes and the transactions,
+// which are applied when the context has no deadline. 0 means no timeout.
+//
+// The read timeout is applied to the queries including reading the rows.
+// The transaction timeout is applied to the whole of the transaction by DB.Transaction and DB.TransactionTx,
+// but not to the transaction by DB.Begin, which is limited by SetMaxTxAge.
+func (db *DB) SetDefaultTimeouts(read, write, tx time.Duration) {
+	db.timeoutMu.Lock()
+	defer db.timeoutMu.Unlock()
+	db.readTimeout = read
+	db.writeTimeout = write
+	db.txTimeout = tx
+}
+
+// timeout returns the timeout of the call.
+func (db *DB) timeout(ctx context.Context, info *QueryInfo) time.Duration {
+	if info.Op == OpBegin {
+		// The context of the begin is the context of the transaction, which must be alive until it ends.
+		return 0
+	}
+	if d, ok := ctx.Value(timeoutKey{}).(time.Duration); ok {
+		return d
+	}
+	if _, ok := ctx.Deadline(); ok {
+		return 0
+	}
+	db.timeoutMu.Lock()
+	defer db.timeoutMu.Unlock()
+	switch info.Op {
+	case OpQuery:
+		return db.readTimeout
+	case OpExec:
+		return db.writeTimeout
+	case OpPrepare:
+		if StatementKind(info.Query) == "select" {
+			return db.readTimeout
+		}
+		return db.writeTimeout
+	case OpTransaction:
+		return db.txTimeout
+	}
+	return 0
+}
+
+// withTimeout returns a new context with the timeout of the call.
+// The context of the queries is canceled at the deadline, because the rows are read after the call returns.
+func (db *DB) withTimeout(ctx context.Context, info *QueryInfo) (context.Context, time.Duration, context.CancelFunc) {
+	d := db.timeout(ctx, info)
+	if d <= 0 {
+		return ctx, 0, func() {}
+	}
+	ctx, cancel := context.WithTimeout(ctx, d)
+	if info.Op == OpQuery {
+		// Releases the context after it is done by the deadline.
+		time.AfterFunc(d, func() {
+			<-ctx.Done()
+			cancel()
+		})
+		return ctx, d, func() {}
+	}
+	return ctx, d, cancel
+}
@@ -0,0 +1,127 @@
+package sqlw_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/glassonion1/sqlw"
+	"github.com/glassonion1/sqlw/sqlwtest"
+)
+
+func TestDBSetDefaultTimeouts(t *testing.T) {
+	tests := []struct {
+		name    string
+		ctx     func() (context.Context, context.CancelFunc)
+		slow    string
+		call    func(ctx context.Context, db *sqlw.DB) error
+		node    string
+		timeout time.Duration
+	}{
+		{
+			name: "read",
+			slow: "replica0",
+			call: func(ctx context.Context, db *sqlw.DB) error {
+				_, err := db.Query(ctx, "SELECT * FROM users")
+				return err
+			},
+			node:    "replica0",
+			timeout: 10 * time.Millisecond,
+		},
+		{
+			name: "write",
+			slow: "master",
+			call: func(ctx context.Context, db *sqlw.DB) error {
+				_, err := db.Exec(ctx, "DELETE FROM users")
+				return err
+			},
+			node:    "master",
+			timeout: 20 * time.Millisecond,
+		},
+		{
+			name: "transaction",
+			call: func(ctx context.Context, db *sqlw.DB) error {
+				return db.Transaction(ctx, func(ctx context.Context, tx *sqlw.Tx) error {
+					<-ctx.Done()
+					return ctx.Err()
+				})
+			},
+			node:    "master",
+			timeout: 30 * time.Millisecond,
+		},
+		{
+			name: "override",
+			ctx: func() (context.Context, context.CancelFunc) {
+				return sqlw.WithQueryTimeout(context.Background(), 5*time.Millisecond), func() {}
+			},
+			slow: "master",
+			call: func(ctx context.Context, db *sqlw.DB) error {
+				_, err := db.Exec(ctx, "DELETE FROM users")
+				return err
+			},
+			node:    "master",
+			timeout: 5 * time.Millisecond,
+		},
+		{
+			name: "deadline of caller",
+			ctx: func() (context.Context, context.CancelFunc) {
+				return context.WithTimeout(context.Background(), 5*time.Millisecond)
+			},
+			slow: "master",
+			call: func(ctx context.Context, db *sqlw.DB) error {
+				_, err := db.Exec(ctx, "DELETE FROM users")
+				return err
+			},
+			node: "master",
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			c := sqlwtest.NewCluster(t)
+			db := sqlw.NewDB(c.Open(t, "master"), c.Open(t, "replica0"))
+			db.SetDefaultTimeouts(10*time.Millisecond, 20*time.Millisecond, 30*time.Millisecond)
+			if tt.slow != "" {
+				c.Update(tt.slow, func(n *sqlwtest.Node) {
+					n.Latency = time.Second
+				})
+			}
+			ctx, cancel := context.Background(), func() {}
+			if tt.ctx != nil {
+				ctx, cancel = tt.ctx()
+			}
+			defer cancel()
+
+			err := tt.call(ctx, db)
+			var timeoutErr *sqlw.TimeoutError
+			if !errors.As(err, &timeoutErr) {
+				t.Fatalf("should be timeout error but got: %v", err)
+			}
+			if timeoutErr.Node != tt.node || timeoutErr.Timeout != tt.timeout {
+				t.Errorf("should be timeout of %s after %v but got: %v", tt.node, tt.timeout, timeoutErr)
+			}
+			if got := sqlw.ClassifyError(err); got != sqlw.ErrorClassTimeout {
+				t.Errorf("should be %s but got: %s", sqlw.ErrorClassTimeout, got)
+			}
+		})
+	}
+}
+
+func TestDBSetDefaultTimeoutsRows(t *testing.T) {
+	c := sqlwtest.NewCluster(t)
+	db := sqlw.NewDB(c.Open(t, "master"), c.Open(t, "replica0"))
+	db.SetDefaultTimeouts(time.Second, time.Second, time.Second)
+
+	rows, err := db.Query(context.Background(), "SELECT * FROM users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		t.Fatalf("rows should be readable after the query returns: %v", rows.Err())
+	}
+}
@@ -0,0 +1,454 @@
+package sqlw
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// tokenKind is the kind of the token in the statement.
+type tokenKind int
+
+const (
+	tokenIdent tokenKind = iota
+	tokenString
+	tokenNumber
+	tokenPlaceholder
+	tokenSymbol
+	tokenComment
+)
+
+// token is a token in the statement.
+type token struct {
+	kind tokenKind
+	text string
+}
+
+// tokenize splits the statement into the tokens of MySQL, dropping the whitespaces.
+// It is not a full SQL parser, but it is enough for sanitizing the statements and finding the placeholders.
+func tokenize(query string) []token {
+	return tokenizeDialect(query, DialectMySQL)
+}
+
+// tokenizeDialect splits the statement into the tokens of the dialect.
+// The double quoted strings are the identifiers on PostgreSQL, and the string literals on the others.
+func tokenizeDialect(query string, d Dialect) []token {
+	tokens := []token{}
+	rs := []rune(query)
+	for i := 0; i < len(rs); {
+		r := rs[i]
+		start := i
+		switch {
+		case unicode.IsSpace(r):
+			i++
+			continue
+		case r == '-' && i+1 < len(rs) && rs[i+1] == '-', r == '#':
+			for i < len(rs) && rs[i] != '\n' {
+				i++
+			}
+			tokens = append(tokens, token{tokenComment, string(rs[start:i])})
+		case r == '/' && i+1 < len(rs) && rs[i+1] == '*':
+			i += 2
+			for i < len(rs) && !(rs[i] == '*' && i+1 < len(rs) && rs[i+1] == '/') {
+				i++
+			}
+			i += 2
+			if i > len(rs) {
+				i = len(rs)
+			}
+			tokens = append(tokens, token{tokenComment, string(rs[start:i])})
+		case r == '"' && d == DialectPostgres:
+			i++
+			for i < len(rs) {
+				if rs[i] == '"' {
+					// A doubled quote is an escaped quote.
+					if i+1 < len(rs) && rs[i+1] == '"' {
+						i += 2
+						continue
+					}
+					break
+				}
+				i++
+			}
+			i++
+			if i > len(rs) {
+				i = len(rs)
+			}
+			tokens = append(tokens, token{tokenIdent, string(rs[start:i])})
+		case r == '\'' || r == '"':
+			i++
+			for i < len(rs) {
+				if rs[i] == '\\' {
+					i += 2
+					continue
+				}
+				if rs[i] == r {
+					// A doubled quote is an escaped quote.
+					if i+1 < len(rs) && rs[i+1] == r {
+						i += 2
+						continue
+					}
+					break
+				}
+				i++
+			}
+			i++
+			if i > len(rs) {
+				i = len(rs)
+			}
+			tokens = append(tokens, token{tokenString, string(rs[start:i])})
+		case r == '`':
+			i++
+			for i < len(rs) && rs[i] != '`' {
+				i++
+			}
+			i++
+			if i > len(rs) {
+				i = len(rs)
+			}
+			tokens = append(tokens, token{tokenIdent, string(rs[start:i])})
+		case unicode.IsDigit(r) || (r == '.' && i+1 < len(rs) && unicode.IsDigit(rs[i+1])):
+			for i < len(rs) && (unicode.IsDigit(rs[i]) || rs[i] == '.' || rs[i] == 'e' || rs[i] == 'E' || rs[i] == 'x' || isHexDigit(rs[i])) {
+				i++
+			}
+			tokens = append(tokens, token{tokenNumber, string(rs[start:i])})
+		case r == '?':
+			i++
+			tokens = append(tokens, token{tokenPlaceholder, "?"})
+		case (r == '$' || r == ':') && i+1 < len(rs) && isIdentRune(rs[i+1]):
+			i++
+			for i < len(rs) && isIdentRune(rs[i]) {
+				i++
+			}
+			tokens = append(tokens, token{tokenPlaceholder, string(rs[start:i])})
+		case isIdentRune(r):
+			for i < len(rs) && (isIdentRune(rs[i]) || rs[i] == '.') {
+				i++
+			}
+			tokens = append(tokens, token{tokenIdent, string(rs[start:i])})
+		default:
+			i++
+			// Joins the operators like <=, >=, <>, != and ::.
+			if i < len(rs) && strings.ContainsRune("<>!=:", r) && strings.ContainsRune("=>:", rs[i]) {
+				i++
+			}
+			tokens = append(tokens, token{tokenSymbol, string(rs[start:i])})
+		}
+	}
+	return tokens
+}
+
+func isIdentRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+func isHexDigit(r rune) bool {
+	return strings.ContainsRune("abcdefABCDEF", r)
+}
+
+// isKeyword reports whether the token is the keyword, case insensitively.
+func (t token) isKeyword(kw string) bool {
+	return t.kind == tokenIdent && strings.EqualFold(t.text, kw)
+}
+
+// Sanitize replaces the literals in the statement with placeholders, drops the comments and collapses the whitespaces,
+// so that the statement can be recorded without the values.
+// The statement is tokenized as MySQL, see Dialect.Sanitize for the other dialects.
+func Sanitize(query string) string {
+	return DialectMySQL.Sanitize(query)
+}
+
+// Sanitize is Sanitize for the statement of the dialect, such as "column" of the identifier on PostgreSQL.
+func (d Dialect) Sanitize(query string) string {
+	return strings.Join(sanitize(query, d), " ")
+}
+
+func sanitize(query string, d Dialect) []string {
+	parts := []string{}
+	for _, t := range tokenizeDialect(query, d) {
+		switch t.kind {
+		case tokenComment:
+			continue
+		case tokenString, tokenNumber, tokenPlaceholder:
+			parts = append(parts, "?")
+		case tokenIdent:
+			parts = append(parts, strings.ToLower(t.text))
+		default:
+			parts = append(parts, t.text)
+		}
+	}
+	return parts
+}
+
+// Fingerprint returns the normalized statement for grouping the statements, such as in the metrics and the slow query log.
+// In addition to Sanitize, it collapses the lists of IN (...) and the rows of VALUES (...), (...) into "(?+)",
+// so that the statements that differ only in the number of the values have the same fingerprint.
+// It starts with the same keyword as the statement, so StatementKind of the fingerprint is the same as the statement.
+// The statement is tokenized as MySQL, see Dialect.Fingerprint for the other dialects.
+func Fingerprint(query string) string {
+	return DialectMySQL.Fingerprint(query)
+}
+
+// Fingerprint is Fingerprint for the statement of the dialect.
+func (d Dialect) Fingerprint(query string) string {
+	parts := sanitize(query, d)
+	list := []string{}
+	for i := 0; i < len(parts); i++ {
+		p := parts[i]
+		list = append(list, p)
+		if p != "in" && p != "values" {
+			continue
+		}
+		end := valueListEnd(parts, i+1)
+		// Collapses the rows of VALUES.
+		for p == "values" && end > 0 && end+1 < len(parts) && parts[end+1] == "," {
+			next := valueListEnd(parts, end+2)
+			if next < 0 {
+				break
+			}
+			end = next
+		}
+		if end > 0 {
+			list = append(list, "(?+)")
+			i = end
+		}
+	}
+	return strings.Join(list, " ")
+}
+
+// valueListEnd returns the index of ")" of "( ? , ? )" starting at i, or -1 if it is not a list of values.
+func valueListEnd(parts []string, i int) int {
+	if i >= len(parts) || parts[i] != "(" {
+		return -1
+	}
+	for j := i + 1; j < len(parts); j += 2 {
+		if parts[j] != "?" || j+1 >= len(parts) {
+			return -1
+		}
+		switch parts[j+1] {
+		case ")":
+			return j + 1
+		case ",":
+		default:
+			return -1
+		}
+	}
+	return -1
+}
+
+// Digest returns the hex encoded hash of the fingerprint of the statement.
+// The statement is tokenized as MySQL, see Dialect.Digest for the other dialects.
+func Digest(query string) string {
+	return DialectMySQL.Digest(query)
+}
+
+// Digest is Digest for the statement of the dialect.
+func (d Dialect) Digest(query string) string {
+	sum := sha256.Sum256([]byte(d.Fingerprint(query)))
+	return hex.EncodeToString(sum[:8])
+}
+
+// statementKinds are the kinds of the statements returned by StatementKind.
+var statementKinds = map[string]bool{
+	"select": true, "insert": true, "update": true, "delete": true, "replace": true,
+}
+
+// StatementKind returns the kind of the statement, "select", "insert", "update", "delete" or "replace".
+// It returns "other" for the other statements.
+func StatementKind(query string) string {
+	for _, t := range tokenize(query) {
+		if t.kind == tokenComment {
+			continue
+		}
+		if kind := strings.ToLower(t.text); t.kind == tokenIdent && statementKinds[kind] {
+			return kind
+		}
+		break
+	}
+	return "other"
+}
+
+// isReadOnlyStatement reports whether the statement is a SELECT statement that neither locks the rows nor writes the result.
+func isReadOnlyStatement(query string) bool {
+	if StatementKind(query) != "select" {
+		return false
+	}
+	tokens := tokenize(query)
+	for i, t := range tokens {
+		var next token
+		if i+1 < len(tokens) {
+			next = tokens[i+1]
+		}
+		switch {
+		case t.isKeyword("into"):
+			return false
+		case t.isKeyword("for") && (next.isKeyword("update") || next.isKeyword("share") || next.isKeyword("no") || next.isKeyword("key")):
+			return false
+		case t.isKeyword("lock") && next.isKeyword("in"):
+			return false
+		}
+	}
+	return true
+}
+
+// placeholderCount returns the number of the args the statement takes, or -1 if it is unknown,
+// such as with the named placeholders like :name or the mixed placeholders.
+// The numbered placeholders like $1 take the args up to the largest number.
+func placeholderCount(query string) int {
+	count, max := 0, 0
+	for _, t := range tokenize(query) {
+		if t.kind != tokenPlaceholder {
+			continue
+		}
+		if t.text == "?" {
+			count++
+			continue
+		}
+		if !strings.HasPrefix(t.text, "$") {
+			return -1
+		}
+		n, err := strconv.Atoi(t.text[1:])
+		if err != nil {
+			return -1
+		}
+		if n > max {
+			max = n
+		}
+	}
+	if count > 0 && max > 0 {
+		return -1
+	}
+	if max > 0 {
+		return max
+	}
+	return count
+}
+
+// comparisons are the operators that compare a column with the placeholder.
+var comparisons = map[string]bool{
+	"=": true, "<": true, ">": true, "<=": true, ">=": true, "<>": true, "!=": true,
+	"like": true, "in": true, "(": true, ",": true,
+}
+
+// placeholderColumns returns the column of each placeholder, or "" if it is unknown.
+// The columns are found from "column = ?", "column IN (?, ?)" and "INSERT INTO table(columns) VALUES(?, ?)".
+// The numbered placeholders like $1 are indexed by their numbers.
+func placeholderColumns(query string, d Dialect) []string {
+	tokens := []token{}
+	for _, t := range tokenizeDialect(query, d) {
+		if t.kind != tokenComment {
+			tokens = append(tokens, t)
+		}
+	}
+
+	// The column list of INSERT INTO table(columns) VALUES
+	insertCols := []string{}
+	inValues := false
+	valuePos := 0
+	depth := 0
+
+	columns := []string{}
+	set := func(t token, col string) {
+		idx := len(columns)
+		if strings.HasPrefix(t.text, "$") {
+			n := 0
+			for _, r := range t.text[1:] {
+				if r < '0' || r > '9' {
+					n = 0
+					break
+				}
+				n = n*10 + int(r-'0')
+			}
+			if n > 0 {
+				idx = n - 1
+			}
+		}
+		for len(columns) <= idx {
+			columns = append(columns, "")
+		}
+		if columns[idx] == "" {
+			columns[idx] = col
+		}
+	}
+
+	for i, t := range tokens {
+		switch {
+		case t.isKeyword("insert") || t.isKeyword("replace"):
+			insertCols = insertCols[:0]
+			j := i + 1
+			for j < len(tokens) && tokens[j].text != "(" && !tokens[j].isKeyword("values") {
+				j++
+			}
+			if j < len(tokens) && tokens[j].text == "(" {
+				for j++; j < len(tokens) && tokens[j].text != ")"; j++ {
+					if tokens[j].kind == tokenIdent {
+						insertCols = append(insertCols, columnName(tokens[j].text))
+					}
+				}
+			}
+		case t.isKeyword("values"):
+			inValues = len(insertCols) > 0
+			depth = 0
+		case inValues && t.text == "(":
+			depth++
+			if depth == 1 {
+				valuePos = 0
+			}
+		case inValues && t.text == ")":
+			depth--
+		case inValues && t.text == "," && depth == 1:
+			valuePos++
+		case inValues && depth == 0 && t.kind == tokenIdent:
+			inValues = false
+		}
+
+		if t.kind != tokenPlaceholder {
+			continue
+		}
+		if inValues && depth == 1 && valuePos < len(insertCols) {
+			set(t, insertCols[valuePos])
+			continue
+		}
+		set(t, comparedColumn(tokens[:i]))
+	}
+	return columns
+}
+
+// comparedColumn returns the column compared with the placeholder after the tokens.
+func comparedColumn(tokens []token) string {
+	i := len(tokens) - 1
+	// Skips the preceding items of IN (?, ?, ?)
+	for i >= 0 && (tokens[i].kind == tokenPlaceholder || tokens[i].text == ",") {
+		i--
+	}
+	if i >= 0 && tokens[i].text == "(" {
+		i--
+	}
+	if i >= 0 && tokens[i].isKeyword("not") {
+		i--
+	}
+	if i < 0 || !comparisons[strings.ToLower(tokens[i].text)] {
+		return ""
+	}
+	if tokens[i].text == "(" || tokens[i].text == "," {
+		return ""
+	}
+	i--
+	if i >= 0 && tokens[i].isKeyword("not") {
+		i--
+	}
+	if i >= 0 && tokens[i].kind == tokenIdent {
+		return columnName(tokens[i].text)
+	}
+	return ""
+}
+
+// columnName drops the table name and the quotes from the column.
+func columnName(s string) string {
+	if i := strings.LastIndex(s, "."); i >= 0 {
+		s = s[i+1:]
+	}
+	return strings.Trim(s, "`\"")
+}
@@ -0,0 +1,147 @@
+package sqlw_test
+
+import (
+	"testing"
+
+	"github.com/glassonion1/sqlw"
+)
+
+func TestSanitize(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{
+			name: "literals",
+			in:   "SELECT * FROM users WHERE name = 'foo''s' AND age > 20 AND score < -1.5e3",
+			want: "select * from users where name = ? and age > ? and score < - ?",
+		},
+		{
+			name: "comments and whitespaces",
+			in:   "/* app */ SELECT id\n\tFROM users -- all\nWHERE id = ? # mysql",
+			want: "select id from users where id = ?",
+		},
+		{
+			name: "placeholders and quoted identifiers",
+			in:   "UPDATE `users` SET name = :name WHERE id = $1",
+			want: "update `users` set name = ? where id = ?",
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			if got := sqlw.Sanitize(tt.in); got != tt.want {
+				t.Errorf("should be %q but got: %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestDialectSanitize(t *testing.T) {
+	tests := []struct {
+		name    string
+		dialect sqlw.Dialect
+		in      string
+		want    string
+	}{
+		{
+			name:    "double quoted identifiers on postgres",
+			dialect: sqlw.DialectPostgres,
+			in:      `UPDATE "users" SET "Name" = 'foo' WHERE "a""b" = $1`,
+			want:    `update "users" set "name" = ? where "a""b" = ?`,
+		},
+		{
+			name:    "double quoted strings on mysql",
+			dialect: sqlw.DialectMySQL,
+			in:      `UPDATE users SET name = "foo" WHERE id = ?`,
+			want:    "update users set name = ? where id = ?",
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			if got := tt.dialect.Sanitize(tt.in); got != tt.want {
+				t.Errorf("should be %q but got: %q", tt.want, got)
+			}
+			if got, want := tt.dialect.Digest(tt.in), tt.dialect.Digest(tt.want); got != want {
+				t.Errorf("digest should be %s but got: %s", want, got)
+			}
+		})
+	}
+}
+
+func TestStatementKind(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{in: "SELECT * FROM users", want: "select"},
+		{in: "/* app */ insert INTO users VALUES(?)", want: "insert"},
+		{in: "  Update users SET name = ?", want: "update"},
+		{in: "DELETE FROM users", want: "delete"},
+		{in: "SHOW TABLES", want: "other"},
+		{in: "", want: "other"},
+	}
+
+	for _, tt := range tests {
+		if got := sqlw.StatementKind(tt.in); got != tt.want {
+			t.Errorf("%q should be %s but got: %s", tt.in, tt.want, got)
+		}
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	tests := []struct {
+		name string
+		in   []string
+		want string
+	}{
+		{
+			name: "in lists",
+			in: []string{
+				"SELECT * FROM users WHERE id IN (?, ?, ?) AND status = 'active'",
+				"select *  from users where id in (1, 2) and status = ?",
+				"/* app */ SELECT * FROM users WHERE id IN ('a') AND status = 'x'",
+			},
+			want: "select * from users where id in (?+) and status = ?",
+		},
+		{
+			name: "rows of values",
+			in: []string{
+				"INSERT INTO users(id, name) VALUES(?, ?)",
+				"INSERT INTO users(id, name) VALUES (1, 'foo'), (2, 'bar')",
+			},
+			want: "insert into users ( id , name ) values (?+)",
+		},
+		{
+			name: "sub query",
+			in: []string{
+				"SELECT * FROM users WHERE id IN (SELECT user_id FROM orders WHERE total > 100)",
+			},
+			want: "select * from users where id in ( select user_id from orders where total > ? )",
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			for _, in := range tt.in {
+				if got := sqlw.Fingerprint(in); got != tt.want {
+					t.Errorf("should be %q but got: %q", tt.want, got)
+				}
+				if got, want := sqlw.Digest(in), sqlw.Digest(tt.in[0]); got != want {
+					t.Errorf("digest should be %s but got: %s", want, got)
+				}
+				if got, want := sqlw.StatementKind(sqlw.Fingerprint(in)), sqlw.StatementKind(in); got != want {
+					t.Errorf("kind should be %s but got: %s", want, got)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,269 @@
+package sqlw
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// topologyDrainTimeout is the max time to wait for the calls in flight on the nodes removed by ApplyTopology.
+const topologyDrainTimeout = time.Minute
+
+// Topology describes the master and the read replicas of the database.
+// Each database is identified by host:port of its config,
+// and is opened again if the rest of its config, such as the credentials, is changed.
+type Topology struct {
+	Master   Config   `json:"master" yaml:"master"`
+	Replicas []Config `json:"replicas" yaml:"replicas"`
+}
+
+// TopologyProvider provides the current topology of the database.
+type TopologyProvider interface {
+	Topology(ctx context.Context) (Topology, error)
+}
+
+// TopologySubscriber is implemented by the TopologyProvider that notifies the changes of the topology.
+// The channel should be closed when the context is done.
+type TopologySubscriber interface {
+	Subscribe(ctx context.Context) <-chan Topology
+}
+
+// WatchTopology keeps the database in sync with the provider until the context is done.
+// If the provider implements TopologySubscriber, the notified topologies are applied,
+// otherwise the provider is polled at every interval.
+// A topology that fails to be provided or applied is retried at the next interval.
+//
+// It blocks until the context is done, so it is usually called in a new goroutine.
+func (db *DB) WatchTopology(ctx context.Context, p TopologyProvider, interval time.Duration) error {
+	if s, ok := p.(TopologySubscriber); ok {
+		for t := range s.Subscribe(ctx) {
+			_ = db.ApplyTopology(ctx, t)
+		}
+		return ctx.Err()
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if t, err := p.Topology(ctx); err == nil {
+			_ = db.ApplyTopology(ctx, t)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// ApplyTopology changes the master and the read replicas of the database to the topology.
+// The removed databases are drained and closed in the background, and the added databases are opened with the connector.
+// The databases opened with the connector are opened again if their configs are changed,
+// the databases opened by the caller are kept as they are.
+// The master is kept as it is if the master of the topology has neither host nor port.
+func (db *DB) ApplyTopology(ctx context.Context, t Topology) error {
+	db.topoMu.RLock()
+	connector := db.connector
+	db.topoMu.RUnlock()
+	if connector == nil {
+		return ErrNoConnector
+	}
+
+	master, replicas := db.nodes()
+
+	desired := map[string]Config{}
+	for _, conf := range t.Replicas {
+		desired[conf.name()] = conf
+	}
+	current := map[string]*node{}
+	for _, r := range replicas {
+		current[r.name] = r
+	}
+
+	errList := []string{}
+
+	// Removes the replicas first so that a replica can be promoted to the master.
+	for _, r := range replicas {
+		if _, ok := desired[r.name]; ok {
+			continue
+		}
+		removed, err := db.removeReplica(r.name)
+		if err != nil {
+			errList = append(errList, err.Error())
+			continue
+		}
+		db.drainInBackground(removed)
+	}
+
+	if t.Master.Host != "" || t.Master.Port != "" {
+		switch {
+		case t.Master.name() != master.name:
+			if err := db.replaceMasterWith(ctx, connector, t.Master); err != nil {
+				errList = append(errList, err.Error())
+			}
+		case master.conf != nil && *master.conf != t.Master:
+			if err := db.reopenWith(ctx, connector, t.Master); err != nil {
+				errList = append(errList, err.Error())
+			}
+		}
+	}
+
+	for name, conf := range desired {
+		if r, ok := current[name]; ok {
+			if r.conf != nil && *r.conf != conf {
+				if err := db.reopenWith(ctx, connector, conf); err != nil {
+					errList = append(errList, err.Error())
+				}
+			}
+			continue
+		}
+		r, err := openWith(ctx, connector, conf)
+		if err != nil {
+			errList = append(errList, err.Error())
+			continue
+		}
+		if err := db.addReplica(name, r, &conf); err != nil {
+			r.Close()
+			errList = append(errList, err.Error())
+		}
+	}
+
+	if len(errList) > 0 {
+		str := strings.Join(errList, ",")
+		return errors.New(str)
+	}
+	return nil
+}
+
+func (db *DB) replaceMasterWith(ctx context.Context, connector Connector, conf Config) error {
+	m, err := connector(conf)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %v", conf.name(), err)
+	}
+	old, err := db.replaceMaster(conf.name(), m, &conf)
+	if err != nil {
+		m.Close()
+		return err
+	}
+	db.drainInBackground(old)
+	return nil
+}
+
+// reopenWith opens the database with the changed config, and replaces the node of the same name with it.
+// The node is kept as it is if the database fails to be opened.
+func (db *DB) reopenWith(ctx context.Context, connector Connector, conf Config) error {
+	sdb, err := openWith(ctx, connector, conf)
+	if err != nil {
+		return err
+	}
+	old, err := db.reopen(conf.name(), sdb, conf)
+	if err != nil {
+		sdb.Close()
+		return err
+	}
+	db.drainInBackground(old)
+	return nil
+}
+
+// drainInBackground drains and closes the node in a new goroutine,
+// so that the calls in flight on the node, such as a long transaction, do not block the following topologies.
+// The node is closed after topologyDrainTimeout even if the calls do not finish.
+func (db *DB) drainInBackground(n *node) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), topologyDrainTimeout)
+		defer cancel()
+		if err := n.drain(ctx); err != nil {
+			db.getLogger().Log(ctx, LevelWarn, "sqlw: node closed before the calls finish", map[string]interface{}{
+				"node":  n.name,
+				"error": err.Error(),
+			})
+		}
+	}()
+}
+
+// openWith opens the database with the connector and checks the connection.
+func openWith(ctx context.Context, connector Connector, conf Config) (*sql.DB, error) {
+	sdb, err := connector(conf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %v", conf.name(), err)
+	}
+	if err := sdb.PingContext(ctx); err != nil {
+		sdb.Close()
+		return nil, fmt.Errorf("failed to ping %s: %v", conf.name(), err)
+	}
+	return sdb, nil
+}
+
+// FileTopologyProvider provides the topology written in a JSON or YAML file.
+// The file is parsed as YAML if its extension is ".yaml" or ".yml", otherwise as JSON.
+//
+//	{
+//	  "master": {"user": "root", "password": "password", "host": "127.0.0.1", "port": "3306", "dbname": "app"},
+//	  "replicas": [
+//	    {"user": "root", "password": "password", "host": "127.0.0.1", "port": "3307", "dbname": "app"}
+//	  ]
+//	}
+//
+// The same topology in YAML is below.
+//
+//	master: {user: root, password: password, host: 127.0.0.1, port: "3306", dbname: app}
+//	replicas:
+//	  - {user: root, password: password, host: 127.0.0.1, port: "3307", dbname: app}
+//
+// The file is read again only when its modification time changes.
+type FileTopologyProvider struct {
+	path    string
+	mu      sync.Mutex
+	modTime time.Time
+	topo    Topology
+}
+
+// NewFileTopologyProvider returns a new provider of the topology written in the file.
+func NewFileTopologyProvider(path string) *FileTopologyProvider {
+	return &FileTopologyProvider{
+		path: path,
+	}
+}
+
+// Topology reads the topology from the file.
+func (p *FileTopologyProvider) Topology(ctx context.Context) (Topology, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	info, err := os.Stat(p.path)
+	if err != nil {
+		return Topology{}, err
+	}
+	if info.ModTime().Equal(p.modTime) {
+		return p.topo, nil
+	}
+
+	b, err := ioutil.ReadFile(p.path)
+	if err != nil {
+		return Topology{}, err
+	}
+	t := Topology{}
+	switch strings.ToLower(filepath.Ext(p.path)) {
+	case ".yaml", ".yml":
+		if err := yaml.Unmarshal(b, &t); err != nil {
+			return Topology{}, fmt.Errorf("failed to parse %s as YAML: %v", p.path, err)
+		}
+	default:
+		if err := json.Unmarshal(b, &t); err != nil {
+			return Topology{}, fmt.Errorf("failed to parse %s as JSON: %v", p.path, err)
+		}
+	}
+	p.modTime = info.ModTime()
+	p.topo = t
+	return t, nil
+}
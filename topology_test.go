@@ -0,0 +1,352 @@
+package sqlw_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/glassonion1/sqlw"
+	"github.com/glassonion1/sqlw/sqlwtest"
+)
+
+// queriedNodes returns the set of nodes that received the query n times.
+func queriedNodes(t *testing.T, db *sqlw.DB, n int) map[string]bool {
+	t.Helper()
+	got := map[string]bool{}
+	for i := 0; i < n; i++ {
+		var name string
+		if err := db.QueryRow(context.Background(), "SELECT node").Scan(&name); err != nil {
+			t.Fatal(err)
+		}
+		got[name] = true
+	}
+	return got
+}
+
+func TestDBAddReplica(t *testing.T) {
+	c := sqlwtest.NewCluster(t)
+	db := sqlw.NewDB(c.Open(t, "master"), c.Open(t, "replica0"))
+
+	if err := db.AddReplica("replica1", c.Open(t, "replica1")); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]bool{"replica0": true, "replica1": true}
+	if diff := cmp.Diff(queriedNodes(t, db, 100), want); diff != "" {
+		t.Errorf("failed to add replica: %v", diff)
+	}
+
+	err := db.AddReplica("replica0", c.Open(t, "replica0"))
+	if !errors.Is(err, sqlw.ErrNodeExists) {
+		t.Errorf("should be error of %v but got: %v", sqlw.ErrNodeExists, err)
+	}
+}
+
+func TestDBRemoveReplica(t *testing.T) {
+	c := sqlwtest.NewCluster(t)
+	db := sqlw.NewDB(c.Open(t, "master"), c.Open(t, "replica0"), c.Open(t, "replica1"))
+	ctx := context.Background()
+
+	if err := db.RemoveReplica(ctx, "replica0"); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]bool{"replica1": true}
+	if diff := cmp.Diff(queriedNodes(t, db, 100), want); diff != "" {
+		t.Errorf("failed to remove replica: %v", diff)
+	}
+
+	if err := db.RemoveReplica(ctx, "replica1"); err != nil {
+		t.Fatal(err)
+	}
+	want = map[string]bool{"master": true}
+	if diff := cmp.Diff(queriedNodes(t, db, 10), want); diff != "" {
+		t.Errorf("should read from the master without replicas: %v", diff)
+	}
+
+	err := db.RemoveReplica(ctx, "replica1")
+	if !errors.Is(err, sqlw.ErrNodeNotFound) {
+		t.Errorf("should be error of %v but got: %v", sqlw.ErrNodeNotFound, err)
+	}
+}
+
+func TestDBRemoveReplicaDrains(t *testing.T) {
+	c := sqlwtest.NewCluster(t)
+	db := sqlw.NewDB(c.Open(t, "master"), c.Open(t, "replica0"))
+	c.Update("replica0", func(n *sqlwtest.Node) {
+		n.Latency = 100 * time.Millisecond
+	})
+
+	done := make(chan error)
+	go func() {
+		var name string
+		done <- db.QueryRow(context.Background(), "SELECT node").Scan(&name)
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	if err := db.RemoveReplica(context.Background(), "replica0"); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Errorf("in-flight query should finish: %v", err)
+	}
+}
+
+func TestDBReplaceMaster(t *testing.T) {
+	c := sqlwtest.NewCluster(t)
+	db := sqlw.NewDB(c.Open(t, "master"), c.Open(t, "replica0"))
+	ctx := context.Background()
+
+	if err := db.ReplaceMaster(ctx, "master2", c.Open(t, "master2")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(ctx, "UPDATE users SET name='foo'"); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(c.Stmts("master2"), []string{"UPDATE users SET name='foo'"}); diff != "" {
+		t.Errorf("should execute on the new master: %v", diff)
+	}
+	if err := db.Writable(); err != nil {
+		t.Errorf("new master should be writable: %v", err)
+	}
+
+	err := db.ReplaceMaster(ctx, "replica0", c.Open(t, "replica0"))
+	if !errors.Is(err, sqlw.ErrNodeExists) {
+		t.Errorf("should be error of %v but got: %v", sqlw.ErrNodeExists, err)
+	}
+}
+
+func TestDBApplyTopology(t *testing.T) {
+	c := sqlwtest.NewCluster(t)
+	connector := func(conf sqlw.Config) (*sql.DB, error) {
+		return c.Open(t, net.JoinHostPort(conf.Host, conf.Port)), nil
+	}
+	ctx := context.Background()
+
+	db := sqlw.NewDB(c.Open(t, "master"))
+	if err := db.ApplyTopology(ctx, sqlw.Topology{}); !errors.Is(err, sqlw.ErrNoConnector) {
+		t.Errorf("should be error of %v but got: %v", sqlw.ErrNoConnector, err)
+	}
+	db.SetConnector(connector)
+
+	topo := sqlw.Topology{
+		Master: sqlw.Config{Host: "10.0.0.1", Port: "3306"},
+		Replicas: []sqlw.Config{
+			{Host: "10.0.0.2", Port: "3306"},
+			{Host: "10.0.0.3", Port: "3306"},
+		},
+	}
+	if err := db.ApplyTopology(ctx, topo); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]bool{"10.0.0.2:3306": true, "10.0.0.3:3306": true}
+	if diff := cmp.Diff(queriedNodes(t, db, 100), want); diff != "" {
+		t.Errorf("failed to apply replicas: %v", diff)
+	}
+
+	// Promotes a replica to the master
+	topo = sqlw.Topology{
+		Master: sqlw.Config{Host: "10.0.0.2", Port: "3306"},
+		Replicas: []sqlw.Config{
+			{Host: "10.0.0.3", Port: "3306"},
+		},
+	}
+	if err := db.ApplyTopology(ctx, topo); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(ctx, "DELETE FROM users"); err != nil {
+		t.Fatal(err)
+	}
+	if got := c.Stmts("10.0.0.1:3306"); len(got) != 0 {
+		t.Errorf("old master should not execute: %v", got)
+	}
+	got := c.Stmts("10.0.0.2:3306")
+	if got[len(got)-1] != "DELETE FROM users" {
+		t.Errorf("promoted master should execute: %v", got)
+	}
+	want = map[string]bool{"10.0.0.3:3306": true}
+	if diff := cmp.Diff(queriedNodes(t, db, 10), want); diff != "" {
+		t.Errorf("failed to apply replicas: %v", diff)
+	}
+}
+
+func TestDBApplyTopologyDrain(t *testing.T) {
+	c := sqlwtest.NewCluster(t)
+	connector := func(conf sqlw.Config) (*sql.DB, error) {
+		return c.Open(t, net.JoinHostPort(conf.Host, conf.Port)), nil
+	}
+	ctx := context.Background()
+
+	db := sqlw.NewDB(c.Open(t, "master"))
+	db.SetConnector(connector)
+	topo := sqlw.Topology{Replicas: []sqlw.Config{{Host: "10.0.0.2", Port: "3306"}}}
+	if err := db.ApplyTopology(ctx, topo); err != nil {
+		t.Fatal(err)
+	}
+	// The read only transaction on the replica is in flight until it ends.
+	tx, err := db.Begin(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		topo := sqlw.Topology{Replicas: []sqlw.Config{{Host: "10.0.0.3", Port: "3306"}}}
+		done <- db.ApplyTopology(ctx, topo)
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the removed replica should be drained in the background")
+	}
+	if diff := cmp.Diff(queriedNodes(t, db, 10), map[string]bool{"10.0.0.3:3306": true}); diff != "" {
+		t.Errorf("failed to apply replicas: %v", diff)
+	}
+
+	var name string
+	if err := tx.QueryRow(ctx, "SELECT node").Scan(&name); err != nil || name != "10.0.0.2:3306" {
+		t.Errorf("the transaction on the removed replica should be alive: %v %s", err, name)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestDBApplyTopologyCredentials(t *testing.T) {
+	c := sqlwtest.NewCluster(t)
+	var mu sync.Mutex
+	passwords := map[string][]string{}
+	connector := func(conf sqlw.Config) (*sql.DB, error) {
+		name := net.JoinHostPort(conf.Host, conf.Port)
+		mu.Lock()
+		defer mu.Unlock()
+		passwords[name] = append(passwords[name], conf.Password)
+		return c.Open(t, name), nil
+	}
+	ctx := context.Background()
+
+	db := sqlw.NewDB(c.Open(t, "master"))
+	db.SetConnector(connector)
+	topo := sqlw.Topology{
+		Master:   sqlw.Config{Host: "10.0.0.1", Port: "3306", Password: "old"},
+		Replicas: []sqlw.Config{{Host: "10.0.0.2", Port: "3306", Password: "old"}},
+	}
+	if err := db.ApplyTopology(ctx, topo); err != nil {
+		t.Fatal(err)
+	}
+	// The same topology changes nothing.
+	if err := db.ApplyTopology(ctx, topo); err != nil {
+		t.Fatal(err)
+	}
+
+	topo.Master.Password = "new"
+	topo.Replicas[0].Password = "new"
+	if err := db.ApplyTopology(ctx, topo); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][]string{
+		"10.0.0.1:3306": {"old", "new"},
+		"10.0.0.2:3306": {"old", "new"},
+	}
+	mu.Lock()
+	if diff := cmp.Diff(passwords, want); diff != "" {
+		t.Errorf("should open the databases again with the new credentials: %v", diff)
+	}
+	mu.Unlock()
+
+	if _, err := db.Exec(ctx, "DELETE FROM users"); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(queriedNodes(t, db, 10), map[string]bool{"10.0.0.2:3306": true}); diff != "" {
+		t.Errorf("failed to keep the replica: %v", diff)
+	}
+	var names []string
+	for _, n := range db.Nodes() {
+		names = append(names, n.Name)
+	}
+	if diff := cmp.Diff(names, []string{"10.0.0.1:3306", "10.0.0.2:3306"}); diff != "" {
+		t.Errorf("failed to keep the topology: %v", diff)
+	}
+}
+
+func TestFileTopologyProvider(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+	}{
+		{
+			name: "json",
+			file: "topology.json",
+			content: `{
+  "master": {"user": "root", "password": "password", "host": "127.0.0.1", "port": "3306", "dbname": "app"},
+  "replicas": [
+    {"user": "root", "password": "password", "host": "127.0.0.1", "port": "3307", "dbname": "app"}
+  ]
+}`,
+		},
+		{
+			name: "yaml",
+			file: "topology.yaml",
+			content: `master:
+  user: root
+  password: password
+  host: 127.0.0.1
+  port: "3306"
+  dbname: app
+replicas:
+  - {user: root, password: password, host: 127.0.0.1, port: "3307", dbname: app}
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			dir, err := ioutil.TempDir("", "sqlw")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			path := filepath.Join(dir, tt.file)
+			if err := ioutil.WriteFile(path, []byte(tt.content), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			p := sqlw.NewFileTopologyProvider(path)
+			got, err := p.Topology(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			conf := sqlw.Config{User: "root", Password: "password", Host: "127.0.0.1", Port: "3306", DBName: "app"}
+			rep := conf
+			rep.Port = "3307"
+			want := sqlw.Topology{Master: conf, Replicas: []sqlw.Config{rep}}
+			if diff := cmp.Diff(got, want); diff != "" {
+				t.Errorf("failed to read topology: %v", diff)
+			}
+
+			if err := ioutil.WriteFile(path, []byte("{"), 0600); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Chtimes(path, time.Now(), time.Now().Add(time.Second)); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := p.Topology(context.Background()); err == nil {
+				t.Errorf("should be error for invalid %s", tt.name)
+			}
+		})
+	}
+}
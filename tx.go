@@ -3,15 +3,72 @@ package sqlw
 import (
 	"context"
 	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"sync"
+	"time"
 )
 
+// ErrReadOnlyTx is returned when executing a mutation in the read only transaction.
+var ErrReadOnlyTx = errors.New("transaction is read only")
+
 // TxHandlerFunc is for executing SQL on a transaction.
 // To make the SQL to be executed a transition target, must execute it via the type sqlw.Tx.
 type TxHandlerFunc func(context.Context, *Tx) error
 
 // Tx is a wrapper around sql.Tx
 type Tx struct {
-	parent *sql.Tx
+	parent txConn
+	db     *DB
+	node   *node
+	// readOnly is true if the transaction is begun with sql.TxOptions{ReadOnly: true}.
+	readOnly bool
+	// depth is the depth of the nested transaction, 0 for the outermost transaction.
+	depth     int
+	callbacks txCallbacks
+	// manual is true if the transaction is begun by DB.Begin.
+	manual  bool
+	started time.Time
+	caller  string
+	endMu   sync.Mutex
+	ended   bool
+}
+
+// txConn is *sql.Tx or the connection the transaction is started on by the statement.
+type txConn interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	PrepareContext(ctx context.Context, query string) (*sql.Stmt, error)
+	Commit() error
+	Rollback() error
+}
+
+// connTx is the transaction started on the connection by the statement,
+// such as START TRANSACTION WITH CONSISTENT SNAPSHOT which database/sql does not support.
+type connTx struct {
+	*sql.Conn
+}
+
+func (t *connTx) end(stmt string) error {
+	if _, err := t.Conn.ExecContext(context.Background(), stmt); err != nil {
+		// The transaction may be left open on the connection, so it is discarded instead of returned to the pool.
+		_ = t.Conn.Raw(func(interface{}) error {
+			return driver.ErrBadConn
+		})
+		return err
+	}
+	return t.Conn.Close()
+}
+
+// Commit commits the transaction and returns the connection to the pool, or discards it if the commit fails.
+func (t *connTx) Commit() error {
+	return t.end("COMMIT")
+}
+
+// Rollback rolls back the transaction and returns the connection to the pool, or discards it if the rollback fails.
+func (t *connTx) Rollback() error {
+	return t.end("ROLLBACK")
 }
 
 // Query executes a query that returns rows, typically a SELECT.
@@ -19,21 +76,67 @@ func (tx *Tx) Query(ctx context.Context, query SQLQuery, args ...interface{}) (*
 	if err := query.Validate(); err != nil {
 		return nil, err
 	}
-	return tx.parent.QueryContext(ctx, query.String(), args...)
+	var rows *sql.Rows
+	info := &QueryInfo{Op: OpQuery, Query: query.String(), Args: args, InTx: true}
+	err := tx.db.run(ctx, info, tx.node, func(ctx context.Context) error {
+		var err error
+		rows, err = tx.parent.QueryContext(ctx, tx.db.comment(ctx, query.String()), args...)
+		return err
+	})
+	return rows, err
 }
 
-// QueryRow executes a query that is expected to return at most one row. QueryRow always returns a non-nil value. Errors are deferred until Row's Scan method is called. If the query selects no rows, the *Row's Scan will return ErrNoRows. Otherwise, the *Row's Scan scans the first selected row and discards the rest.
+// QueryRow executes a query that is expected to return at most one row. QueryRow returns nil only if the query is invalid. Errors are deferred until Row's Scan method is called, including the errors rejecting the query before it is sent, such as the errors of the hooks. If the query selects no rows, the *Row's Scan will return ErrNoRows. Otherwise, the *Row's Scan scans the first selected row and discards the rest.
 func (tx *Tx) QueryRow(ctx context.Context, query SQLQuery, args ...interface{}) *sql.Row {
 	if err := query.Validate(); err != nil {
 		return nil
 	}
-	return tx.parent.QueryRowContext(ctx, query.String(), args...)
+	var row *sql.Row
+	info := &QueryInfo{Op: OpQuery, Query: query.String(), Args: args, InTx: true}
+	err := tx.db.run(ctx, info, tx.node, func(ctx context.Context) error {
+		row = tx.parent.QueryRowContext(ctx, tx.db.comment(ctx, query.String()), args...)
+		return row.Err()
+	})
+	if row == nil {
+		return errRow(err)
+	}
+	return row
 }
 
 // Exec executes a query without returning any rows. The args are for any placeholder parameters in the query.
+// It returns ErrReadOnlyTx in the read only transaction.
 func (tx *Tx) Exec(ctx context.Context, query SQLMutation, args ...interface{}) (sql.Result, error) {
 	if err := query.Validate(); err != nil {
 		return nil, err
 	}
-	return tx.parent.ExecContext(ctx, query.String(), args...)
+	if tx.readOnly {
+		return nil, ErrReadOnlyTx
+	}
+	var res sql.Result
+	info := &QueryInfo{Op: OpExec, Query: query.String(), Args: args, InTx: true}
+	err := tx.db.run(ctx, info, tx.node, func(ctx context.Context) error {
+		var err error
+		res, err = tx.parent.ExecContext(ctx, tx.db.comment(ctx, query.String()), args...)
+		if err == nil {
+			if affected, aerr := res.RowsAffected(); aerr == nil {
+				info.RowsAffected = affected
+			}
+		}
+		return err
+	})
+	return res, err
+}
+
+func (tx *Tx) commit(ctx context.Context) error {
+	info := &QueryInfo{Op: OpCommit, InTx: true}
+	return tx.db.run(ctx, info, tx.node, func(context.Context) error {
+		return tx.parent.Commit()
+	})
+}
+
+func (tx *Tx) rollback(ctx context.Context) error {
+	info := &QueryInfo{Op: OpRollback, InTx: true}
+	return tx.db.run(ctx, info, tx.node, func(context.Context) error {
+		return tx.parent.Rollback()
+	})
 }
@@ -0,0 +1,142 @@
+package sqlw
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+)
+
+// txCallbacks are the callbacks registered on the transaction.
+type txCallbacks struct {
+	mu       sync.Mutex
+	commit   []func(context.Context)
+	rollback []func(context.Context, error)
+	// done are the callbacks of the rolled back nested transactions,
+	// which run whether the outermost transaction commits or not.
+	done []func(context.Context)
+}
+
+// AfterCommit registers the function called after the transaction commits.
+// It is not called if the transaction rolls back.
+//
+// The functions are called by DB.Transaction in the order of the registration after the outermost transaction ends,
+// so the function in the nested transaction is called only if both the nested and the outermost transactions commit.
+// The panic in the function is recovered and notified as EventTxCallbackPanicked.
+func (tx *Tx) AfterCommit(fn func(ctx context.Context)) {
+	tx.callbacks.mu.Lock()
+	defer tx.callbacks.mu.Unlock()
+	tx.callbacks.commit = append(tx.callbacks.commit, fn)
+}
+
+// AfterRollback registers the function called with the error after the transaction rolls back.
+// It is also called if the commit fails.
+//
+// The functions are called by DB.Transaction in the order of the registration after the outermost transaction ends.
+// The function in the nested transaction is called if the nested transaction rolls back to the savepoint,
+// or if the outermost transaction rolls back.
+// The panic in the function is recovered and notified as EventTxCallbackPanicked.
+func (tx *Tx) AfterRollback(fn func(ctx context.Context, err error)) {
+	tx.callbacks.mu.Lock()
+	defer tx.callbacks.mu.Unlock()
+	tx.callbacks.rollback = append(tx.callbacks.rollback, fn)
+}
+
+// Transaction executes the function in the nested transaction using the savepoint.
+// It releases the savepoint if there is no error, and rolls back to the savepoint and returns the error otherwise,
+// so the outer transaction can continue after the nested transaction fails.
+func (tx *Tx) Transaction(ctx context.Context, fn TxHandlerFunc) error {
+	return tx.TransactionTx(ctx, fn, nil)
+}
+
+// TransactionTx executes the function in the nested transaction using the savepoint as Transaction.
+// Only ReadOnly of the options is applied, and Tx.Exec returns ErrReadOnlyTx in the nested transaction.
+// The other options are ignored because the transaction has already begun.
+func (tx *Tx) TransactionTx(ctx context.Context, fn TxHandlerFunc, opts *sql.TxOptions) error {
+	nested := &Tx{
+		parent:   tx.parent,
+		db:       tx.db,
+		node:     tx.node,
+		readOnly: tx.readOnly || (opts != nil && opts.ReadOnly),
+		depth:    tx.depth + 1,
+	}
+	savepoint := fmt.Sprintf("sqlw_savepoint_%d", nested.depth)
+	if err := tx.exec(ctx, "SAVEPOINT "+savepoint); err != nil {
+		return fmt.Errorf("failed to create savepoint: %w", err)
+	}
+
+	if err := fn(ctx, nested); err != nil {
+		if re := tx.exec(ctx, "ROLLBACK TO SAVEPOINT "+savepoint); re != nil {
+			return fmt.Errorf("failed to rollback to savepoint: %v: %w", re, err)
+		}
+		tx.callbacks.merge(&nested.callbacks, err)
+		return err
+	}
+	if err := tx.exec(ctx, "RELEASE SAVEPOINT "+savepoint); err != nil {
+		return fmt.Errorf("failed to release savepoint: %w", err)
+	}
+	tx.callbacks.merge(&nested.callbacks, nil)
+	return nil
+}
+
+// exec executes the statement controlling the transaction.
+func (tx *Tx) exec(ctx context.Context, stmt string) error {
+	info := &QueryInfo{Op: OpExec, Query: stmt, InTx: true}
+	return tx.db.run(ctx, info, tx.node, func(ctx context.Context) error {
+		_, err := tx.parent.ExecContext(ctx, stmt)
+		return err
+	})
+}
+
+// merge moves the callbacks of the nested transaction.
+// If the nested transaction rolled back with the error, its rollback callbacks are called whatever the outcome is,
+// and its commit callbacks are dropped.
+func (c *txCallbacks) merge(nested *txCallbacks, err error) {
+	nested.mu.Lock()
+	defer nested.mu.Unlock()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.done = append(c.done, nested.done...)
+	if err == nil {
+		c.commit = append(c.commit, nested.commit...)
+		c.rollback = append(c.rollback, nested.rollback...)
+		return
+	}
+	for _, fn := range nested.rollback {
+		fn := fn
+		c.done = append(c.done, func(ctx context.Context) {
+			fn(ctx, err)
+		})
+	}
+}
+
+// runCallbacks calls the callbacks of the outermost transaction that ended with the error.
+func (tx *Tx) runCallbacks(ctx context.Context, err error) {
+	tx.callbacks.mu.Lock()
+	done, commit, rollback := tx.callbacks.done, tx.callbacks.commit, tx.callbacks.rollback
+	tx.callbacks.mu.Unlock()
+
+	for _, fn := range done {
+		tx.call(func() { fn(ctx) })
+	}
+	if err == nil {
+		for _, fn := range commit {
+			tx.call(func() { fn(ctx) })
+		}
+		return
+	}
+	for _, fn := range rollback {
+		tx.call(func() { fn(ctx, err) })
+	}
+}
+
+// call calls the callback, recovering the panic.
+func (tx *Tx) call(fn func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			tx.db.emit(Event{Type: EventTxCallbackPanicked, Node: tx.node.name, Err: fmt.Errorf("panic in transaction callback: %v", r)})
+		}
+	}()
+	fn()
+}
@@ -0,0 +1,215 @@
+package sqlw_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/glassonion1/sqlw"
+	"github.com/glassonion1/sqlw/sqlwtest"
+)
+
+func TestTxAfterCommit(t *testing.T) {
+	errTx := errors.New("tx error")
+	tests := []struct {
+		name string
+		err  error
+		want []string
+	}{
+		{
+			name: "commit",
+			err:  nil,
+			want: []string{"commit1", "commit2"},
+		},
+		{
+			name: "rollback",
+			err:  errTx,
+			want: []string{"rollback1", "rollback2"},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			c := sqlwtest.NewCluster(t)
+			db := sqlw.NewDB(c.Open(t, "master"))
+
+			calls := []string{}
+			err := db.Transaction(context.Background(), func(ctx context.Context, tx *sqlw.Tx) error {
+				tx.AfterCommit(func(ctx context.Context) {
+					calls = append(calls, "commit1")
+				})
+				tx.AfterRollback(func(ctx context.Context, err error) {
+					if err == nil {
+						t.Error("should be called with the error")
+					}
+					calls = append(calls, "rollback1")
+				})
+				tx.AfterCommit(func(ctx context.Context) {
+					// The transaction has ended before the callback.
+					if got := c.Stmts("master"); got[len(got)-1] != "COMMIT" {
+						t.Errorf("should be called after commit: %v", got)
+					}
+					calls = append(calls, "commit2")
+				})
+				tx.AfterRollback(func(ctx context.Context, err error) {
+					calls = append(calls, "rollback2")
+				})
+				return tt.err
+			})
+			if (err != nil) != (tt.err != nil) {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(calls, tt.want); diff != "" {
+				t.Errorf("failed to call callbacks: %v", diff)
+			}
+		})
+	}
+}
+
+func TestTxAfterCommitPanic(t *testing.T) {
+	c := sqlwtest.NewCluster(t)
+	db := sqlw.NewDB(c.Open(t, "master"))
+	events := []sqlw.Event{}
+	db.OnEvent(func(e sqlw.Event) {
+		events = append(events, e)
+	})
+
+	called := false
+	err := db.Transaction(context.Background(), func(ctx context.Context, tx *sqlw.Tx) error {
+		tx.AfterCommit(func(ctx context.Context) {
+			panic("boom")
+		})
+		tx.AfterCommit(func(ctx context.Context) {
+			called = true
+		})
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !called {
+		t.Error("should call the callback after the panic")
+	}
+	if len(events) != 1 || events[0].Type != sqlw.EventTxCallbackPanicked || events[0].Node != "master" {
+		t.Fatalf("should notify the panic: %+v", events)
+	}
+	if got := events[0].Err.Error(); got != "panic in transaction callback: boom" {
+		t.Errorf("unexpected error: %s", got)
+	}
+}
+
+func TestTxAfterCommitTransaction(t *testing.T) {
+	c := sqlwtest.NewCluster(t)
+	db := sqlw.NewDB(c.Open(t, "master"))
+	ctx := context.Background()
+
+	// The callback can begin another transaction.
+	err := db.Transaction(ctx, func(ctx context.Context, tx *sqlw.Tx) error {
+		tx.AfterCommit(func(ctx context.Context) {
+			err := db.Transaction(ctx, func(ctx context.Context, tx *sqlw.Tx) error {
+				_, err := tx.Exec(ctx, "INSERT INTO outbox(id) VALUES(1)")
+				return err
+			})
+			if err != nil {
+				t.Error(err)
+			}
+		})
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"BEGIN", "COMMIT", "BEGIN", "INSERT INTO outbox(id) VALUES(1)", "COMMIT"}
+	if diff := cmp.Diff(c.Stmts("master"), want); diff != "" {
+		t.Errorf("failed to execute transactions: %v", diff)
+	}
+}
+
+func TestTxTransaction(t *testing.T) {
+	errNested := errors.New("nested error")
+	errTx := errors.New("tx error")
+	tests := []struct {
+		name      string
+		nestedErr error
+		err       error
+		stmts     []string
+		calls     []string
+	}{
+		{
+			name:  "commit both",
+			stmts: []string{"BEGIN", "SAVEPOINT sqlw_savepoint_1", "UPDATE users SET name='foo'", "RELEASE SAVEPOINT sqlw_savepoint_1", "COMMIT"},
+			calls: []string{"commit:outer", "commit:nested"},
+		},
+		{
+			name:      "rollback nested",
+			nestedErr: errNested,
+			stmts:     []string{"BEGIN", "SAVEPOINT sqlw_savepoint_1", "UPDATE users SET name='foo'", "ROLLBACK TO SAVEPOINT sqlw_savepoint_1", "COMMIT"},
+			calls:     []string{"rollback:nested:nested error", "commit:outer"},
+		},
+		{
+			name:  "rollback outer",
+			err:   errTx,
+			stmts: []string{"BEGIN", "SAVEPOINT sqlw_savepoint_1", "UPDATE users SET name='foo'", "RELEASE SAVEPOINT sqlw_savepoint_1", "ROLLBACK"},
+			calls: []string{"rollback:outer", "rollback:nested"},
+		},
+		{
+			name:      "rollback both",
+			nestedErr: errNested,
+			err:       errTx,
+			stmts:     []string{"BEGIN", "SAVEPOINT sqlw_savepoint_1", "UPDATE users SET name='foo'", "ROLLBACK TO SAVEPOINT sqlw_savepoint_1", "ROLLBACK"},
+			calls:     []string{"rollback:nested:nested error", "rollback:outer"},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			c := sqlwtest.NewCluster(t)
+			db := sqlw.NewDB(c.Open(t, "master"))
+
+			calls := []string{}
+			_ = db.Transaction(context.Background(), func(ctx context.Context, tx *sqlw.Tx) error {
+				tx.AfterCommit(func(ctx context.Context) {
+					calls = append(calls, "commit:outer")
+				})
+				tx.AfterRollback(func(ctx context.Context, err error) {
+					calls = append(calls, "rollback:outer")
+				})
+				err := tx.Transaction(ctx, func(ctx context.Context, tx *sqlw.Tx) error {
+					tx.AfterCommit(func(ctx context.Context) {
+						calls = append(calls, "commit:nested")
+					})
+					tx.AfterRollback(func(ctx context.Context, err error) {
+						if errors.Is(err, errNested) {
+							calls = append(calls, "rollback:nested:"+err.Error())
+							return
+						}
+						calls = append(calls, "rollback:nested")
+					})
+					if _, err := tx.Exec(ctx, "UPDATE users SET name='foo'"); err != nil {
+						return err
+					}
+					return tt.nestedErr
+				})
+				if err != tt.nestedErr {
+					t.Errorf("should be error of %v but got: %v", tt.nestedErr, err)
+				}
+				return tt.err
+			})
+
+			if diff := cmp.Diff(c.Stmts("master"), tt.stmts); diff != "" {
+				t.Errorf("failed to execute statements: %v", diff)
+			}
+			if diff := cmp.Diff(calls, tt.calls); diff != "" {
+				t.Errorf("failed to call callbacks: %v", diff)
+			}
+		})
+	}
+}